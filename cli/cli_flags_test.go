@@ -1,11 +1,13 @@
 package cli
 
 import (
+	"crypto/tls"
 	"github.com/stretchr/testify/assert"
 	"github.com/tednaleid/ganda/config"
 	"math"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestHelp(t *testing.T) {
@@ -17,7 +19,7 @@ func TestHelp(t *testing.T) {
 }
 
 func TestVersion(t *testing.T) {
-	results, _ := ParseGandaArgs([]string{"ganda", "-v"})
+	results, _ := ParseGandaArgs([]string{"ganda", "--version"})
 	assert.NotNil(t, results)
 	assert.Nil(t, results.GetContext()) // context isn't set up when version is called
 	assert.Equal(t, "", results.stderr)
@@ -94,3 +96,165 @@ func TestResponseBodyFlags(t *testing.T) {
 		}
 	}
 }
+
+func TestFailureLimitFlags(t *testing.T) {
+	results, _ := ParseGandaArgs([]string{"ganda"})
+	assert.Equal(t, 0, results.GetContext().MaxFailures)
+	assert.Equal(t, 0.0, results.GetContext().MaxFailureRate)
+	assert.Equal(t, 100, results.GetContext().FailureWindow)
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--max-failures", "10", "--max-failure-rate", "0.25", "--failure-window", "1000"})
+	assert.Equal(t, 10, results.GetContext().MaxFailures)
+	assert.Equal(t, 0.25, results.GetContext().MaxFailureRate)
+	assert.Equal(t, 1000, results.GetContext().FailureWindow)
+}
+
+func TestInvalidFailureLimitFlags(t *testing.T) {
+	testCases := []struct {
+		args  []string
+		error string
+	}{
+		{[]string{"--max-failure-rate", "1.5"}, "invalid max-failure-rate value: 1.5"},
+		{[]string{"--failure-window", "0"}, "invalid failure-window value: 0"},
+	}
+
+	for _, tc := range testCases {
+		results, _ := ParseGandaArgs(append([]string{"ganda"}, tc.args...))
+		assert.Nil(t, results.GetContext())
+		assert.Contains(t, results.stderr, tc.error)
+	}
+}
+
+func TestCompressRequestBodyFlag(t *testing.T) {
+	results, _ := ParseGandaArgs([]string{"ganda"})
+	assert.Equal(t, "", results.GetContext().RequestBodyEncoding)
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--compress-request-body", "zstd"})
+	assert.Equal(t, "zstd", results.GetContext().RequestBodyEncoding)
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--compress-request-body", "br"})
+	assert.Nil(t, results.GetContext())
+	assert.Contains(t, results.stderr, "invalid compress-request-body value: br")
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	results, _ := ParseGandaArgs([]string{"ganda"})
+	assert.Equal(t, "", results.GetContext().AcceptEncoding)
+	assert.Empty(t, results.GetContext().RequestHeaders)
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--accept-encoding", "br, zstd", "--save-compressed"})
+	assert.Equal(t, "br, zstd", results.GetContext().AcceptEncoding)
+	assert.True(t, results.GetContext().SaveCompressed)
+	assert.Equal(t, []config.RequestHeader{{Key: "Accept-Encoding", Value: "br, zstd"}}, results.GetContext().RequestHeaders)
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--accept-encoding", "br", "-H", "accept-encoding: identity"})
+	assert.Equal(t, []config.RequestHeader{{Key: "accept-encoding", Value: "identity"}}, results.GetContext().RequestHeaders)
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--accept-encoding", "gzip,deflate"})
+	assert.Nil(t, results.GetContext())
+	assert.Contains(t, results.stderr, "invalid accept-encoding value: deflate")
+}
+
+func TestTLSFlags(t *testing.T) {
+	results, _ := ParseGandaArgs([]string{"ganda", "--tls-min-version", "1.3", "--server-name", "api.example.com"})
+	assert.Equal(t, uint16(tls.VersionTLS13), results.GetContext().TLSConfig.MinVersion)
+	assert.Equal(t, "api.example.com", results.GetContext().TLSConfig.ServerName)
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--tls-min-version", "1.4"})
+	assert.Nil(t, results.GetContext())
+	assert.Contains(t, results.stderr, "invalid tls-min-version value: 1.4")
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--cacert", "does-not-exist.pem"})
+	assert.Nil(t, results.GetContext())
+	assert.Contains(t, results.stderr, "Error: unable to read --cacert: open does-not-exist.pem")
+}
+
+func TestRedirectFlags(t *testing.T) {
+	results, _ := ParseGandaArgs([]string{"ganda"})
+	assert.Equal(t, 10, results.GetContext().MaxRedirects)
+	assert.False(t, results.GetContext().NoFollowRedirects)
+	assert.False(t, results.GetContext().SameHostRedirects)
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--max-redirects", "3", "--no-follow-redirects", "--same-host-redirects"})
+	assert.Equal(t, 3, results.GetContext().MaxRedirects)
+	assert.True(t, results.GetContext().NoFollowRedirects)
+	assert.True(t, results.GetContext().SameHostRedirects)
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--max-redirects", "-1"})
+	assert.Nil(t, results.GetContext())
+	assert.Contains(t, results.stderr, "invalid max-redirects value: -1, must not be negative")
+}
+
+func TestConnectionPoolFlags(t *testing.T) {
+	results, _ := ParseGandaArgs([]string{"ganda"})
+	assert.Equal(t, 1, results.GetContext().ConnectionPools)
+	assert.Equal(t, 0, results.GetContext().MaxConnsPerHost)
+	assert.Equal(t, 500, results.GetContext().MaxIdleConns)
+	assert.Equal(t, 90*time.Second, results.GetContext().IdleConnTimeout)
+	assert.False(t, results.GetContext().Summary)
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--connection-pools", "4", "--max-conns-per-host", "20", "--max-idle-conns", "100", "--idle-conn-timeout", "30s", "--summary"})
+	assert.Equal(t, 4, results.GetContext().ConnectionPools)
+	assert.Equal(t, 20, results.GetContext().MaxConnsPerHost)
+	assert.Equal(t, 100, results.GetContext().MaxIdleConns)
+	assert.Equal(t, 30*time.Second, results.GetContext().IdleConnTimeout)
+	assert.True(t, results.GetContext().Summary)
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--connection-pools", "0"})
+	assert.Nil(t, results.GetContext())
+	assert.Contains(t, results.stderr, "invalid connection-pools value: 0, must be at least 1")
+}
+
+func TestHttpVersionFlags(t *testing.T) {
+	results, _ := ParseGandaArgs([]string{"ganda"})
+	assert.Nil(t, results.GetContext().HTTPProtocols)
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--http1.1"})
+	assert.Equal(t, "{HTTP1}", results.GetContext().HTTPProtocols.String())
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--http2"})
+	assert.Equal(t, "{HTTP2}", results.GetContext().HTTPProtocols.String())
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--http2-prior-knowledge"})
+	assert.Equal(t, "{HTTP2,UnencryptedHTTP2}", results.GetContext().HTTPProtocols.String())
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--http1.1", "--http2"})
+	assert.Nil(t, results.GetContext())
+	assert.Contains(t, results.stderr, "only one of --http1.1, --http2 and --http2-prior-knowledge can be used")
+}
+
+func TestVerboseLevels(t *testing.T) {
+	results, _ := ParseGandaArgs([]string{"ganda"})
+	assert.Equal(t, 0, results.GetContext().Logger.Verbosity())
+
+	results, _ = ParseGandaArgs([]string{"ganda", "-v"})
+	assert.Equal(t, 1, results.GetContext().Logger.Verbosity())
+
+	results, _ = ParseGandaArgs([]string{"ganda", "-vv"})
+	assert.Equal(t, 2, results.GetContext().Logger.Verbosity())
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--verbose", "--verbose"})
+	assert.Equal(t, 2, results.GetContext().Logger.Verbosity())
+
+	results, _ = ParseGandaArgs([]string{"ganda", "-vv", "-s"})
+	assert.Equal(t, 0, results.GetContext().Logger.Verbosity())
+}
+
+func TestLogFlags(t *testing.T) {
+	results, _ := ParseGandaArgs([]string{"ganda", "--log-format", "yaml"})
+	assert.Contains(t, results.stderr, "invalid log-format value: yaml")
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--log-level", "trace"})
+	assert.Contains(t, results.stderr, "invalid log-level value: trace")
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--log-format", "json", "--log-level", "warn"})
+	assert.NotNil(t, results.GetContext())
+}
+
+func TestDryRunFlag(t *testing.T) {
+	results, _ := ParseGandaArgs([]string{"ganda", "--dry-run", "yaml"})
+	assert.Contains(t, results.stderr, "invalid dry-run value: yaml")
+
+	results, _ = ParseGandaArgs([]string{"ganda", "--dry-run", "json"})
+	assert.Equal(t, "json", results.GetContext().DryRun)
+}
@@ -1,9 +1,18 @@
 package cli
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"github.com/stretchr/testify/assert"
 	"github.com/tednaleid/ganda/config"
+	"io"
+	"log"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -170,4 +179,173 @@ func TestErrorResponse(t *testing.T) {
 	)
 }
 
+func TestJsonLinesOutputFileOverride(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello ", r.URL.Path)
+	}))
+	defer server.Close()
+
+	outputDirectory := t.TempDir()
+	url := server.urlFor("bar")
+
+	inputLines := `
+		{ "url": "` + url + `", "outputFile": "named/bar.txt" }
+	`
+
+	runResults, _ := RunGanda([]string{"ganda", "--output-directory", outputDirectory}, trimmedInputReader(inputLines))
+
+	fullPath := filepath.Join(outputDirectory, "named", "bar.txt")
+	runResults.assert(t, "", "Response: 200 "+url+" -> "+fullPath+"\n")
+
+	contents, err := os.ReadFile(fullPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello /bar", string(contents))
+}
+
+func TestSaveCompressedKeepsEncodedBytes(t *testing.T) {
+	t.Parallel()
+	compressed := new(bytes.Buffer)
+	writer := gzip.NewWriter(compressed)
+	fmt.Fprint(writer, "Hello /bar")
+	writer.Close()
+
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	outputDirectory := t.TempDir()
+	url := server.urlFor("bar")
+
+	inputLines := `
+		{ "url": "` + url + `", "outputFile": "bar.gz" }
+	`
+
+	runResults, _ := RunGanda([]string{"ganda", "--accept-encoding", "gzip", "--save-compressed", "--output-directory", outputDirectory}, trimmedInputReader(inputLines))
+
+	fullPath := filepath.Join(outputDirectory, "bar.gz")
+	runResults.assert(t, "", "Response: 200 "+url+" -> "+fullPath+"\n")
+
+	contents, err := os.ReadFile(fullPath)
+	assert.NoError(t, err)
+	assert.Equal(t, compressed.Bytes(), contents)
+}
+
 // TODO test the file saving version of this
+
+func TestSourceAddressInJsonEnvelope(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "\"", r.RemoteAddr[:strings.LastIndex(r.RemoteAddr, ":")], "\"")
+	}))
+	defer server.Close()
+
+	runResults, _ := RunGanda([]string{"ganda", "-J", "--source-address", "127.0.0.2"}, server.stubStdinUrl("bar"))
+
+	runResults.assert(
+		t,
+		"{ \"url\": \""+server.urlFor("bar")+"\", \"code\": 200, \"body\": \"127.0.0.2\", \"sourceAddress\": \"127.0.0.2\" }\n",
+		"Response: 200 "+server.urlFor("bar")+"\n",
+	)
+}
+
+func TestRedirectsInJsonEnvelope(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.NewServeMux())
+	defer server.Close()
+
+	mux := server.Config.Handler.(*http.ServeMux)
+	mux.Handle("/old", http.RedirectHandler("/moved", http.StatusMovedPermanently))
+	mux.Handle("/moved", http.RedirectHandler("/new", http.StatusFound))
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "\"new\"")
+	})
+	mux.Handle("/elsewhere", http.RedirectHandler(strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/new", http.StatusFound))
+
+	url := server.urlFor("old")
+
+	runResults, _ := RunGanda([]string{"ganda", "-J"}, strings.NewReader(url))
+
+	runResults.assert(
+		t,
+		"{ \"url\": \""+url+"\", \"code\": 200, \"redirects\": [{\"code\":301,\"location\":\"/moved\"},{\"code\":302,\"location\":\"/new\"}], \"body\": \"new\" }\n",
+		"Response: 200 "+url+"\n",
+	)
+
+	// saved responses are logged with the input url, as are printed ones
+	outputDirectory := t.TempDir()
+	inputLines := `{ "url": "` + url + `", "outputFile": "old.txt" }`
+	runResults, _ = RunGanda([]string{"ganda", "--output-directory", outputDirectory}, strings.NewReader(inputLines))
+
+	runResults.assert(t, "", "Response: 200 "+url+" -> "+filepath.Join(outputDirectory, "old.txt")+"\n")
+
+	runResults, _ = RunGanda([]string{"ganda", "-J", "-B", "discard", "--no-follow-redirects"}, strings.NewReader(url))
+
+	runResults.assert(
+		t,
+		"{ \"url\": \""+url+"\", \"code\": 301, \"body\": null }\n",
+		"Response: 301 "+url+"\n",
+	)
+
+	runResults, _ = RunGanda([]string{"ganda", "-J", "--max-redirects", "1"}, strings.NewReader(url))
+
+	assert.Equal(t, "", runResults.stdout)
+	assert.Contains(t, runResults.stderr, "stopped after 1 redirects, reached --max-redirects")
+
+	elsewhereUrl := server.urlFor("elsewhere")
+	runResults, _ = RunGanda([]string{"ganda", "-J", "-B", "discard", "--same-host-redirects"}, strings.NewReader(elsewhereUrl))
+
+	runResults.assert(
+		t,
+		"{ \"url\": \""+elsewhereUrl+"\", \"code\": 302, \"body\": null }\n",
+		"Response: 302 "+elsewhereUrl+"\n",
+	)
+
+	in := strings.NewReader(`{ "url": "` + url + `", "followRedirects": true }`)
+	runResults, _ = RunGanda([]string{"ganda", "-B", "discard", "--no-follow-redirects"}, in)
+
+	runResults.assert(t, "", "Response: 200 "+url+"\n")
+}
+
+func TestHttpVersionInJsonEnvelope(t *testing.T) {
+	t.Parallel()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "\"", r.Proto, "\"")
+	})
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+
+	url := server.URL + "/bar"
+
+	runResults, _ := RunGanda([]string{"ganda", "-J", "--http2-prior-knowledge"}, strings.NewReader(url))
+	runResults.assert(t, "{ \"url\": \""+url+"\", \"code\": 200, \"body\": \"HTTP/2.0\", \"protocol\": \"HTTP/2.0\" }\n", "Response: 200 "+url+"\n")
+
+	runResults, _ = RunGanda([]string{"ganda", "-J", "--http1.1"}, strings.NewReader(url))
+	runResults.assert(t, "{ \"url\": \""+url+"\", \"code\": 200, \"body\": \"HTTP/1.1\", \"protocol\": \"HTTP/1.1\" }\n", "Response: 200 "+url+"\n")
+
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	tlsUrl := tlsServer.URL + "/bar"
+
+	runResults, _ = RunGanda([]string{"ganda", "-J", "-k", "--http2"}, strings.NewReader(tlsUrl))
+	runResults.assert(t, "{ \"url\": \""+tlsUrl+"\", \"code\": 200, \"body\": \"HTTP/2.0\", \"protocol\": \"HTTP/2.0\" }\n", "Response: 200 "+tlsUrl+"\n")
+
+	http1Server := httptest.NewUnstartedServer(handler)
+	http1Server.Config.ErrorLog = log.New(io.Discard, "", 0) // the failed handshake is expected
+	http1Server.StartTLS()
+	defer http1Server.Close()
+
+	runResults, _ = RunGanda([]string{"ganda", "-J", "-k", "--http2"}, strings.NewReader(http1Server.URL))
+	assert.Equal(t, "", runResults.stdout, "fails when HTTP/2 isn't negotiated")
+	assert.Contains(t, runResults.stderr, http1Server.URL+" Error: ")
+}
@@ -1,9 +1,33 @@
 package cli
 
 import (
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"github.com/andybalholm/brotli"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -114,6 +138,28 @@ func TestRetryEnabledShouldNotRetry4XX(t *testing.T) {
 		"Response: 400 "+url+"\n")
 }
 
+func TestRetryEnabledShouldNotRetryTooManyRedirects(t *testing.T) {
+	t.Parallel()
+	var requestCount atomic.Int32
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bar" {
+			requestCount.Add(1)
+		}
+		http.Redirect(w, r, r.URL.Path+"/next", http.StatusFound)
+	}))
+	defer server.Server.Close()
+
+	runResults, err := RunGanda([]string{"ganda", "--retry", "2", "--base-retry-millis", "1", "--max-redirects", "1"}, server.stubStdinUrl("bar"))
+
+	url := server.urlFor("bar")
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), requestCount.Load(), "every retry would follow the same redirects")
+	runResults.assert(t,
+		"",
+		url+" Error: Get \"/bar/next/next\": stopped after 1 redirects, reached --max-redirects\n")
+}
+
 func TestRetryEnabledShouldRetryTimeout(t *testing.T) {
 	t.Parallel()
 	requestCount := 0
@@ -153,3 +199,876 @@ func TestAddHeadersToRequestCreatesCanonicalKeys(t *testing.T) {
 		"Hello /bar\n",
 		"Response: 200 "+url+"\n")
 }
+
+func TestMaxFailuresAbortsRun(t *testing.T) {
+	t.Parallel()
+	var requestCount atomic.Int32
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		w.WriteHeader(500)
+	}))
+	defer server.Server.Close()
+
+	fragments := make([]string, 100)
+	for i := range fragments {
+		fragments[i] = fmt.Sprintf("bar/%d", i)
+	}
+
+	runResults, err := RunGanda([]string{"ganda", "--max-failures", "2"}, server.stubStdinUrls(fragments))
+
+	assert.Error(t, err, "aborted runs exit non-zero")
+	assert.Less(t, requestCount.Load(), int32(10), "should stop reading input after the second failure")
+	assert.Contains(t, runResults.stderr, "Aborted: 2 of ")
+	assert.Contains(t, runResults.stderr, "reached --max-failures 2, stopped reading input after ")
+}
+
+func TestMaxFailuresSkipsQueuedRequestsWithManyWorkers(t *testing.T) {
+	t.Parallel()
+	var mutex sync.Mutex
+	sent := make(map[string]bool)
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		sent[r.URL.Path] = true
+		mutex.Unlock()
+		w.WriteHeader(500)
+	}))
+	defer server.Server.Close()
+
+	var input strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "%s\tline-%d\n", server.urlFor(fmt.Sprintf("bar/%d", i)), i)
+	}
+
+	runResults, err := RunGanda([]string{"ganda", "-W", "50", "--max-failures", "1"}, strings.NewReader(input.String()))
+
+	assert.Error(t, err, "aborted runs exit non-zero")
+	// the requests in flight when the limit is hit finish, the 50 queued behind them aren't sent
+	assert.LessOrEqual(t, len(sent), 60, "should stop sending near the limit")
+	assert.Contains(t, runResults.stderr, "Aborted: 1 of 1 requests failed, reached --max-failures 1, stopped reading input after 1 completed requests\n")
+
+	// every line taken from the input was either sent or logged as skipped, so the input can be picked back up
+	skipped := regexp.MustCompile(`Skipped: \S+/(bar/\d+) \["line-(\d+)"\], the run was stopped before it was sent`).FindAllStringSubmatch(runResults.stderr, -1)
+	assert.NotEmpty(t, skipped)
+	for _, match := range skipped {
+		assert.Equal(t, "bar/"+match[2], match[1], "the context is logged with its url")
+		assert.False(t, sent["/"+match[1]], "a skipped request isn't sent")
+	}
+	for i := 0; i < len(sent)+len(skipped); i++ {
+		path := fmt.Sprintf("bar/%d", i)
+		assert.True(t, sent["/"+path] || strings.Contains(runResults.stderr, "Skipped: "+server.urlFor(path)+" "), "line %d was dispatched", i)
+	}
+}
+
+func TestMaxFailuresStopsWaitingOnTheThrottle(t *testing.T) {
+	t.Parallel()
+	var requestCount atomic.Int32
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		w.WriteHeader(500)
+	}))
+	defer server.Server.Close()
+
+	fragments := make([]string, 100)
+	for i := range fragments {
+		fragments[i] = fmt.Sprintf("bar/%d", i)
+	}
+
+	start := time.Now()
+	_, err := RunGanda([]string{"ganda", "-W", "20", "--max-failures", "1", "--throttle-per-second", "10"}, server.stubStdinUrls(fragments))
+
+	assert.Error(t, err, "aborted runs exit non-zero")
+	assert.Less(t, time.Since(start), time.Second, "queued requests shouldn't wait for the throttle once aborted")
+	assert.LessOrEqual(t, requestCount.Load(), int32(2))
+}
+
+func TestMaxFailureRateAbortsRun(t *testing.T) {
+	t.Parallel()
+	var requestCount atomic.Int32
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestCount.Add(1) > 5 {
+			w.WriteHeader(503)
+		}
+	}))
+	defer server.Server.Close()
+
+	fragments := make([]string, 100)
+	for i := range fragments {
+		fragments[i] = fmt.Sprintf("bar/%d", i)
+	}
+
+	runResults, err := RunGanda([]string{"ganda", "--max-failure-rate", "0.5", "--failure-window", "4"}, server.stubStdinUrls(fragments))
+
+	assert.Error(t, err, "aborted runs exit non-zero")
+	assert.Less(t, requestCount.Load(), int32(20), "should stop reading input once half the window failed")
+	assert.Contains(t, runResults.stderr, "Aborted: 2 of the last 4 requests failed, reached --max-failure-rate 0.5")
+}
+
+func TestFailuresBelowMaxFailuresDoNotAbort(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer server.Server.Close()
+
+	runResults, err := RunGanda([]string{"ganda", "--max-failures", "2"}, server.stubStdinUrl("bar"))
+
+	assert.NoError(t, err)
+	assert.NotContains(t, runResults.stderr, "Aborted")
+}
+
+// not parallel, the interrupt is sent to the whole test process
+func TestInterruptStopsReadingInputAndFinishesInFlightRequests(t *testing.T) {
+	var requestCount atomic.Int32
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestCount.Add(1) == 1 {
+			syscall.Kill(os.Getpid(), syscall.SIGINT)
+			time.Sleep(50 * time.Millisecond) // still in-flight when the signal is handled
+		}
+		fmt.Fprint(w, "Hello ", r.URL.Path)
+	}))
+	defer server.Server.Close()
+
+	fragments := make([]string, 100)
+	for i := range fragments {
+		fragments[i] = fmt.Sprintf("bar/%d", i)
+	}
+
+	runResults, err := RunGanda([]string{"ganda"}, server.stubStdinUrls(fragments))
+
+	assert.Error(t, err, "interrupted runs exit non-zero")
+	assert.Less(t, requestCount.Load(), int32(10), "should stop reading input after the interrupt")
+	assert.True(t, strings.HasPrefix(runResults.stdout, "Hello /bar/0\n"), "in-flight request output should be written")
+	assert.Contains(t, runResults.stderr, "Received interrupt, finishing in-flight requests")
+	assert.Contains(t, runResults.stderr, "Interrupted: stopped reading input before the end")
+}
+
+// not parallel, the interrupts are sent to the whole test process
+func TestSecondInterruptAbandonsInFlightRequests(t *testing.T) {
+	var requestCount atomic.Int32
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestCount.Add(1) == 1 {
+			syscall.Kill(os.Getpid(), syscall.SIGINT)
+			time.Sleep(10 * time.Millisecond)
+			syscall.Kill(os.Getpid(), syscall.SIGINT)
+		}
+		// only returns when ganda gives up on the request
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			fmt.Fprint(w, "Hello ", r.URL.Path)
+		}
+	}))
+	defer server.Server.Close()
+
+	fragments := make([]string, 100)
+	for i := range fragments {
+		fragments[i] = fmt.Sprintf("bar/%d", i)
+	}
+
+	start := time.Now()
+	runResults, err := RunGanda([]string{"ganda", "-W", "4", "--throttle-per-second", "5"}, server.stubStdinUrls(fragments))
+
+	assert.Error(t, err, "interrupted runs exit non-zero")
+	assert.Less(t, time.Since(start), time.Second, "should exit without waiting for in-flight requests or the throttle")
+	assert.Equal(t, "", runResults.stdout)
+	assert.Contains(t, runResults.stderr, "Received second interrupt, cancelling in-flight requests")
+	assert.Contains(t, runResults.stderr, "Interrupted: stopped reading input before the end, in-flight requests were abandoned")
+	assert.NotContains(t, runResults.stderr, "in-flight requests were completed")
+}
+
+func TestMultipartBodyIsUploaded(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseMultipartForm(1024))
+		file, header, err := r.FormFile("doc")
+		assert.NoError(t, err)
+		contents, _ := io.ReadAll(file)
+		fmt.Fprintf(w, "%s %s %s %d", r.FormValue("title"), header.Filename, contents, r.ContentLength)
+	}))
+	defer server.Server.Close()
+
+	docFile := filepath.Join(t.TempDir(), "doc.txt")
+	os.WriteFile(docFile, []byte("hello"), 0o600)
+
+	url := server.urlFor("upload")
+	in := strings.NewReader(`{ "url": "` + url + `", "method": "POST", "bodyType": "multipart", "body": { "title": "Q3", "doc": { "file": "` + docFile + `" } } }`)
+
+	runResults, _ := RunGanda([]string{"ganda"}, in)
+
+	assert.Regexp(t, `^Q3 doc.txt hello \d+\n$`, runResults.stdout)
+	assert.Equal(t, "Response: 200 "+url+"\n", runResults.stderr)
+}
+
+func TestCompressedRequestBodyIsResentOnRetry(t *testing.T) {
+	t.Parallel()
+	var bodies []string
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		gzipReader, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(gzipReader)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(500)
+		}
+	}))
+	defer server.Server.Close()
+
+	url := server.urlFor("ingest")
+	in := strings.NewReader(`{ "url": "` + url + `", "method": "POST", "body": {"key": "value"} }`)
+
+	runResults, _ := RunGanda([]string{"ganda", "--compress-request-body", "gzip", "--retry", "1", "--base-retry-millis", "1"}, in)
+
+	assert.Equal(t, []string{`{"key": "value"}`, `{"key": "value"}`}, bodies)
+	runResults.assert(t, "", "Response: 500 "+url+"\nResponse: 200 "+url+"\n")
+}
+
+func TestAcceptEncodingDecodesBrotliResponse(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gzip, br, zstd", r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Content-Encoding", "br")
+		writer := brotli.NewWriter(w)
+		fmt.Fprint(writer, "Hello ", r.URL.Path)
+		writer.Close()
+	}))
+	defer server.Server.Close()
+
+	runResults, _ := RunGanda([]string{"ganda", "--accept-encoding", "gzip,br,zstd", "-B", "escaped"}, server.stubStdinUrl("foo/1"))
+
+	runResults.assert(
+		t,
+		"\"Hello /foo/1\"\n",
+		"Response: 200 "+server.urlFor("foo/1")+"\n",
+	)
+}
+
+func TestMutualTLS(t *testing.T) {
+	t.Parallel()
+	directory := t.TempDir()
+	clientCertFile, clientKeyFile, clientCert := writeSelfSignedCertificate(t, directory, "client")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello ", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caCertFile := filepath.Join(directory, "ca.pem")
+	os.WriteFile(caCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+
+	url := server.URL + "/secure"
+
+	// the httptest certificate is for example.com
+	runResults, _ := RunGanda([]string{"ganda", "--cacert", caCertFile, "--cert", clientCertFile, "--key", clientKeyFile,
+		"--server-name", "example.com", "--tls-min-version", "1.2"}, strings.NewReader(url))
+
+	runResults.assert(t, "Hello client\n", "Response: 200 "+url+"\n")
+
+	runResults, _ = RunGanda([]string{"ganda", "--cacert", caCertFile, "--server-name", "example.com"}, strings.NewReader(url))
+
+	assert.Equal(t, "", runResults.stdout)
+	assert.Contains(t, runResults.stderr, url+" Error: ")
+}
+
+func writeSelfSignedCertificate(t *testing.T, directory string, commonName string) (certFile string, keyFile string, cert *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err = x509.ParseCertificate(certDer)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile = filepath.Join(directory, commonName+".pem")
+	keyFile = filepath.Join(directory, commonName+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600)
+	return certFile, keyFile, cert
+}
+
+func TestRequestsAreSentThroughProxy(t *testing.T) {
+	t.Parallel()
+	proxy := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := parseProxyAuthorization(r.Header.Get("Proxy-Authorization"))
+		fmt.Fprint(w, "Proxied ", r.URL.String(), " for ", username, ":", password)
+	}))
+	defer proxy.Server.Close()
+
+	runResults, _ := RunGanda([]string{"ganda", "--proxy", "http://user:secret@" + proxy.Listener.Addr().String()},
+		strings.NewReader("http://partner.example/items/1"))
+
+	runResults.assert(
+		t,
+		"Proxied http://partner.example/items/1 for user:secret\n",
+		"Response: 200 http://partner.example/items/1\n",
+	)
+
+	runResults, _ = RunGanda([]string{"ganda", "--proxy", proxy.URL, "--no-proxy", "partner.example", "--connect-timeout-millis", "200"},
+		strings.NewReader("http://partner.example/items/1"))
+
+	assert.Equal(t, "", runResults.stdout, "partner.example doesn't resolve so the request fails without the proxy")
+}
+
+func parseProxyAuthorization(header string) (username, password string, ok bool) {
+	request := &http.Request{Header: http.Header{"Authorization": {header}}}
+	return request.BasicAuth()
+}
+
+func TestResolveKeepsHostHeader(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello ", r.Host, r.URL.Path)
+	}))
+	defer server.Server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	url := "http://api.example.com:" + port + "/items/1"
+
+	runResults, _ := RunGanda([]string{"ganda", "--resolve", "api.example.com:" + port + ":127.0.0.1"}, strings.NewReader(url))
+
+	runResults.assert(t, "Hello api.example.com:"+port+"/items/1\n", "Response: 200 "+url+"\n")
+
+	url = "http://api.example.com/items/2"
+	runResults, _ = RunGanda([]string{"ganda", "--connect-to", "api.example.com:80:127.0.0.1:" + port}, strings.NewReader(url))
+
+	runResults.assert(t, "Hello api.example.com/items/2\n", "Response: 200 "+url+"\n")
+}
+
+func TestUnixSocket(t *testing.T) {
+	t.Parallel()
+	directory, _ := os.MkdirTemp("", "ganda")
+	defer os.RemoveAll(directory)
+	socket := filepath.Join(directory, "ganda.sock")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello ", r.Host, r.URL.Path)
+	}))
+	server.Listener, _ = net.Listen("unix", socket)
+	server.Start()
+	defer server.Close()
+
+	runResults, _ := RunGanda([]string{"ganda", "--unix-socket", socket}, strings.NewReader("http://docker/containers/json"))
+
+	runResults.assert(t, "Hello docker/containers/json\n", "Response: 200 http://docker/containers/json\n")
+
+	in := strings.NewReader(`{ "url": "http://sidecar/health", "unixSocket": "` + socket + `" }`)
+	runResults, _ = RunGanda([]string{"ganda"}, in)
+
+	runResults.assert(t, "Hello sidecar/health\n", "Response: 200 http://sidecar/health\n")
+}
+
+func TestCookieJarSharesSessionCookie(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc123", Path: "/"})
+		}
+		fmt.Fprint(w, r.URL.Path, " ", r.Header.Get("Cookie"))
+	}))
+	defer server.Server.Close()
+
+	cookieJar := filepath.Join(t.TempDir(), "cookies.txt")
+
+	runResults, err := RunGanda([]string{"ganda", "--cookie-jar", cookieJar, "--cookie", "team=blue"}, server.stubStdinUrls([]string{"login", "items"}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/login team=blue\n/items team=blue; session=abc123\n", runResults.stdout)
+
+	contents, _ := os.ReadFile(cookieJar)
+	assert.Contains(t, string(contents), "127.0.0.1\tFALSE\t/\tFALSE\t0\tsession\tabc123\n")
+
+	// the saved session is loaded by the next run
+	runResults, _ = RunGanda([]string{"ganda", "--cookie-jar", cookieJar}, server.stubStdinUrl("items"))
+
+	assert.Equal(t, "/items session=abc123\n", runResults.stdout)
+}
+
+func TestCookieJarCookiesAreNotRepeatedOnRetries(t *testing.T) {
+	t.Parallel()
+	var attempts atomic.Int32
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "s", Value: "1", Path: "/"})
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, r.Header.Values("Cookie"))
+	}))
+	defer server.Server.Close()
+
+	cookieJar := filepath.Join(t.TempDir(), "cookies.txt")
+
+	runResults, err := RunGanda([]string{"ganda", "--cookie-jar", cookieJar, "--cookie", "team=blue", "--retry", "2", "--base-retry-millis", "1"}, server.stubStdinUrl("bar"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "[team=blue; s=1]\n", runResults.stdout)
+}
+
+func TestWorkersShareConnectionPool(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Server.Close()
+
+	fragments := make([]string, 8)
+	for i := range fragments {
+		fragments[i] = fmt.Sprintf("bar/%d", i)
+	}
+
+	runResults, err := RunGanda([]string{"ganda", "-W", "4", "-B", "discard", "--max-conns-per-host", "1", "--summary"}, server.stubStdinUrls(fragments))
+
+	assert.NoError(t, err)
+	assert.Contains(t, runResults.stderr, "Summary: 8 requests (0 failed), 1 connections opened, 7 connections reused\n")
+}
+
+func TestCredentialsAreReadFromFilesAndEnvironment(t *testing.T) {
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"), " ", r.Header.Get("X-Api-Key"))
+	}))
+	defer server.Server.Close()
+
+	t.Setenv("GANDA_TEST_API_KEY", "env-key")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("file-token\n"), 0o600)
+
+	runResults, err := RunGanda([]string{"ganda", "--bearer-token-file", tokenFile, "-H", "X-Api-Key: @env:GANDA_TEST_API_KEY"}, server.stubStdinUrl("bar"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer file-token env-key\n", runResults.stdout)
+
+	runResults, err = RunGanda([]string{"ganda", "--user", "alice:secret", "-H", "X-Api-Key: @file:" + tokenFile}, server.stubStdinUrl("bar"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Basic YWxpY2U6c2VjcmV0 file-token\n", runResults.stdout)
+
+	runResults, err = RunGanda([]string{"ganda", "-H", "X-Api-Key: @env:GANDA_TEST_MISSING"}, server.stubStdinUrl("bar"))
+
+	assert.Error(t, err)
+	assert.Equal(t, "Error: the X-Api-Key header's environment variable GANDA_TEST_MISSING is not set\n", runResults.stderr)
+}
+
+func TestNetrcCredentialsAreSentToMatchingHost(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		fmt.Fprint(w, username, " ", password, " ", ok)
+	}))
+	defer server.Server.Close()
+
+	netrcFile := filepath.Join(t.TempDir(), "netrc")
+	os.WriteFile(netrcFile, []byte("machine 127.0.0.1 login alice password secret\nmachine example.com login bob password other\n"), 0o600)
+
+	in := trimmedInputReader(`
+		{ "url": "` + server.urlFor("bar") + `" }
+		{ "url": "` + server.urlFor("baz") + `", "headers": { "Authorization": "Basic Ym9iOm90aGVy" } }
+	`)
+
+	runResults, err := RunGanda([]string{"ganda", "--netrc-file", netrcFile}, in)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "alice secret true\nbob other true\n", runResults.stdout)
+}
+
+func TestUrlPasswordIsRedactedFromLogs(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello ", r.URL.Path)
+	}))
+	defer server.Server.Close()
+
+	url := strings.Replace(server.urlFor("bar"), "http://", "http://alice:secret@", 1)
+	runResults, _ := RunGanda([]string{"ganda"}, strings.NewReader(url))
+
+	runResults.assert(t,
+		"Hello /bar\n",
+		"Response: 200 "+strings.Replace(url, "secret", "xxxxx", 1)+"\n")
+}
+
+func TestOAuth2RequestBodyIsResentOnUnauthorized(t *testing.T) {
+	t.Parallel()
+	var tokens atomic.Int32
+	tokenServer := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "bearer", "expires_in": 3600}`, tokens.Add(1))
+	}))
+	defer tokenServer.Server.Close()
+
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, r.Header.Get("Authorization"), " ", string(body))
+	}))
+	defer server.Server.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secretFile, []byte("s3cret"), 0o600)
+
+	input := `{"url":"` + server.urlFor("a") + `","method":"POST","body":{"id":1}}`
+	runResults, err := RunGanda([]string{"ganda", "--oauth2-token-url", tokenServer.URL, "--oauth2-client-id", "ganda", "--oauth2-client-secret-file", secretFile}, strings.NewReader(input))
+
+	assert.NoError(t, err)
+	assert.Equal(t, `Bearer token-2 {"id":1}`+"\n", runResults.stdout)
+}
+
+func TestOAuth2TokenIsRefreshedOnUnauthorized(t *testing.T) {
+	t.Parallel()
+	var tokens atomic.Int32
+	tokenServer := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "bearer", "expires_in": 3600}`, tokens.Add(1))
+	}))
+	defer tokenServer.Server.Close()
+
+	// the first token is revoked after it has been used
+	var revoked atomic.Bool
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if authorization == "Bearer token-1" && !revoked.CompareAndSwap(false, true) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, r.URL.Path, " ", authorization)
+	}))
+	defer server.Server.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secretFile, []byte("s3cret"), 0o600)
+
+	runResults, err := RunGanda([]string{"ganda", "--oauth2-token-url", tokenServer.URL, "--oauth2-client-id", "ganda", "--oauth2-client-secret-file", secretFile}, server.stubStdinUrls([]string{"a", "b", "c"}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/a Bearer token-1\n/b Bearer token-2\n/c Bearer token-2\n", runResults.stdout)
+	assert.Equal(t, int32(2), tokens.Load())
+}
+
+func TestAuthCommandIsRerunOnUnauthorized(t *testing.T) {
+	t.Parallel()
+	rotated := filepath.Join(t.TempDir(), "rotated")
+
+	// the credential is rotated after the first request, the old one is rejected from then on
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if _, err := os.Stat(rotated); err == nil && authorization != "Bearer new-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		os.WriteFile(rotated, nil, 0o600)
+		fmt.Fprint(w, r.URL.Path, " ", authorization)
+	}))
+	defer server.Server.Close()
+
+	authCommand := "if [ -f " + rotated + " ]; then echo new-token; else echo old-token; fi"
+
+	runResults, err := RunGanda([]string{"ganda", "--auth-command", authCommand}, server.stubStdinUrls([]string{"a", "b"}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/a Bearer old-token\n/b Bearer new-token\n", runResults.stdout)
+}
+
+func TestAwsSigV4SignsEachAttempt(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	var attempts atomic.Int32
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Regexp(t, `^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/\d{8}/us-east-1/execute-api/aws4_request, SignedHeaders=host;x-amz-date, Signature=[0-9a-f]{64}$`, r.Header.Get("Authorization"))
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "signed")
+	}))
+	defer server.Server.Close()
+
+	in := trimmedInputReader(`{ "url": "` + server.urlFor("items") + `", "method": "POST", "body": { "id": 1 } }`)
+
+	runResults, err := RunGanda([]string{"ganda", "--aws-sigv4", "execute-api:us-east-1", "--retry", "1", "--base-retry-millis", "1"}, in)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "signed\n", runResults.stdout)
+	assert.Equal(t, int32(2), attempts.Load())
+}
+
+func TestVerboseRedactsSigningHeaders(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "session-token-secret")
+
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("X-Amz-Security-Token"))
+	}))
+	defer server.Server.Close()
+
+	runResults, err := RunGanda([]string{"ganda", "-v", "--aws-sigv4", "execute-api:us-east-1"}, server.stubStdinUrl("items"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "session-token-secret\n", runResults.stdout)
+	assert.Contains(t, runResults.stderr, "> X-Amz-Security-Token: [REDACTED]\n")
+	assert.Contains(t, runResults.stderr, "> Authorization: [REDACTED]\n")
+	assert.NotContains(t, runResults.stderr, "session-token-secret")
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(keyFile, []byte("s3cret\n"), 0o600)
+
+	runResults, err = RunGanda([]string{"ganda", "-v", "--sign-hmac", keyFile, "--sign-hmac-header", "X-Request-Signature"}, server.stubStdinUrl("items"))
+
+	assert.NoError(t, err)
+	assert.Contains(t, runResults.stderr, "> X-Request-Signature: [REDACTED]\n")
+}
+
+func TestSignHmacIsVerifiedByServer(t *testing.T) {
+	t.Parallel()
+	var attempts atomic.Int32
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodyHash := sha256.Sum256(body)
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		fmt.Fprintf(mac, "%s\n%s\n%s\n%x", r.Method, r.URL.EscapedPath(), r.Header.Get("X-Timestamp"), bodyHash)
+
+		if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(r.Header.Get("X-Signature"))) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "verified ", string(body))
+	}))
+	defer server.Server.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(keyFile, []byte("s3cret\n"), 0o600)
+
+	in := trimmedInputReader(`{ "url": "` + server.urlFor("items/1") + `", "method": "PUT", "body": { "id": 1 } }`)
+
+	runResults, err := RunGanda([]string{"ganda", "--sign-hmac", keyFile, "--retry", "1", "--base-retry-millis", "1"}, in)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "verified { \"id\": 1 }\n", runResults.stdout, runResults.stderr)
+}
+
+func TestVerboseLogsRedactedHeaders(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", "Sun, 18 Oct 2026 12:00:00 GMT")
+		if r.URL.Path == "/old" {
+			w.Header().Set("Location", "/new")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc123"})
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "forbidden")
+	}))
+	defer server.Server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	runResults, err := RunGanda([]string{"ganda", "-vv", "--user", "alice:secret", "-H", "X-Trace: abc"}, server.stubStdinUrl("old"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "forbidden\n", runResults.stdout)
+	assert.Equal(t, trimIndent(`
+		> GET `+server.urlFor("old")+`
+		> Authorization: [REDACTED]
+		> Connection: keep-alive
+		> Host: `+host+`
+		> X-Trace: abc
+		< HTTP/1.1 302 Found `+server.urlFor("old")+`
+		< Content-Length: 0
+		< Date: Sun, 18 Oct 2026 12:00:00 GMT
+		< Location: /new
+		> GET `+server.urlFor("new")+`
+		> Authorization: [REDACTED]
+		> Connection: keep-alive
+		> Host: `+host+`
+		> Referer: `+server.urlFor("old")+`
+		> X-Trace: abc
+		< HTTP/1.1 200 OK `+server.urlFor("new")+`
+		< Content-Length: 9
+		< Content-Type: text/plain
+		< Date: Sun, 18 Oct 2026 12:00:00 GMT
+		< Set-Cookie: [REDACTED]
+		<
+		< forbidden
+		Response: 200 `+server.urlFor("old")+`
+	`)+"\n", runResults.stderr)
+}
+
+func TestJsonLogFormat(t *testing.T) {
+	t.Parallel()
+	var attempts atomic.Int32
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Server.Close()
+
+	url := server.urlFor("bar")
+	runResults, err := RunGanda([]string{"ganda", "--log-format", "json", "--retry", "1", "--base-retry-millis", "1"}, server.stubStdinUrl("bar"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ok\n", runResults.stdout)
+
+	var events []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(runResults.stderr), "\n") {
+		var event map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(line), &event))
+		assert.Contains(t, event, "time")
+		assert.Contains(t, event, "duration_ms")
+		delete(event, "time")
+		delete(event, "duration_ms")
+		events = append(events, event)
+	}
+
+	assert.Equal(t, []map[string]any{
+		{"level": "WARN", "msg": "Response: 502 " + url, "event": "response", "status": float64(502), "url": url, "attempt": float64(1)},
+		{"level": "INFO", "msg": "Response: 200 " + url, "event": "response", "status": float64(200), "url": url, "attempt": float64(2)},
+	}, events)
+}
+
+func TestDryRunCurl(t *testing.T) {
+	t.Parallel()
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("dry run sent a request to %s", r.URL)
+	}))
+	defer server.Server.Close()
+
+	url := server.urlFor("bar")
+	input := `{"url":"` + url + `","method":"POST","headers":{"Content-Type":"application/json"},"body":{"name":"it's"}}
+{"url":"` + url + `","bodyType":"base64","body":"AAEC","timeoutMillis":1500,"followRedirects":false}
+{"url":"` + url + `","method":"PUT","bodyType":"escaped","body":"@data.txt"}`
+
+	runResults, err := RunGanda([]string{"ganda", "--dry-run", "curl", "-u", "user:secret", "-H", "X-Trace: a b"}, strings.NewReader(input))
+
+	assert.NoError(t, err)
+	runResults.assert(
+		t,
+		"curl -L -H 'Authorization: [REDACTED]' -H 'Content-Type: application/json' -H 'X-Trace: a b' --data-binary '{\"name\":\"it'\\''s\"}' "+url+"\n"+
+			"curl -X GET --max-time 1.5 -H 'Authorization: [REDACTED]' -H 'X-Trace: a b' --data-binary @<(printf %s AAEC | base64 -d) "+url+"\n"+
+			// curl would read a body starting with @ from that file
+			"curl -X PUT -L -H 'Authorization: [REDACTED]' -H 'X-Trace: a b' --data-binary @<(printf %s QGRhdGEudHh0 | base64 -d) "+url+"\n",
+		"Dry run: 3 requests\n",
+	)
+}
+
+func TestDryRunJsonReadsBackAsTheSameRequest(t *testing.T) {
+	t.Parallel()
+	var received []string
+	var mutex sync.Mutex
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		received = append(received, fmt.Sprintf("%s %s %s %s", r.Method, r.URL.RequestURI(), r.Header.Get("X-Trace"), body))
+		mutex.Unlock()
+	}))
+	defer server.Server.Close()
+
+	input := `{"url":"` + server.urlFor("bar") + `","method":"PUT","query":{"q":"1"},"body":"line 1\nline 2","bodyType":"escaped","context":["ctx"],"retries":2}`
+
+	dryRunResults, err := RunGanda([]string{"ganda", "--dry-run", "json", "-H", "X-Trace: abc"}, strings.NewReader(input))
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"url":"`+server.urlFor("bar?q=1")+`","method":"PUT","headers":[["X-Trace","abc"]],"body":"line 1\nline 2","bodyType":"escaped","context":["ctx"],"retries":2}`+"\n", dryRunResults.stdout)
+	mutex.Lock()
+	assert.Empty(t, received)
+	mutex.Unlock()
+
+	_, err = RunGanda([]string{"ganda"}, strings.NewReader(dryRunResults.stdout))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PUT /bar?q=1 abc line 1\nline 2"}, received)
+}
+
+func TestDryRunPrintsBodyFilePaths(t *testing.T) {
+	t.Parallel()
+	var received []string
+	var mutex sync.Mutex
+	server := NewHttpServerStub(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		received = append(received, fmt.Sprintf("%s %s %d", r.Header.Get("Content-Type"), r.Header.Get("Content-Encoding"), len(body)))
+		mutex.Unlock()
+	}))
+	defer server.Server.Close()
+
+	// larger than a JSON line can be, the file is only read when the request is sent
+	bodyFile := filepath.Join(t.TempDir(), "large.txt")
+	os.WriteFile(bodyFile, []byte(strings.Repeat("a", 800*1024)), 0o600)
+	url := server.urlFor("bar")
+
+	runResults, err := RunGanda([]string{"ganda", "--dry-run", "curl"}, strings.NewReader(url+"\t@file:"+bodyFile+"\tctx"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "curl -X GET -L -H 'Content-Type: text/plain; charset=utf-8' --data-binary @"+bodyFile+" "+url+"\n", runResults.stdout)
+
+	input := `{"url":"` + url + `","method":"PUT","bodyFile":"` + bodyFile + `"}`
+	runResults, err = RunGanda([]string{"ganda", "--dry-run", "curl", "--compress-request-body", "gzip"}, strings.NewReader(input))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "curl -X PUT -L -H 'Content-Encoding: gzip' -H 'Content-Type: text/plain; charset=utf-8' --data-binary @<(gzip -c "+bodyFile+") "+url+"\n", runResults.stdout)
+
+	dryRunResults, err := RunGanda([]string{"ganda", "--dry-run", "json"}, strings.NewReader(input))
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"url":"`+url+`","method":"PUT","headers":[["Content-Type","text/plain; charset=utf-8"]],"bodyFile":"`+bodyFile+`","bodyEncoding":"identity"}`+"\n", dryRunResults.stdout)
+
+	_, err = RunGanda([]string{"ganda", "--compress-request-body", "gzip"}, strings.NewReader(dryRunResults.stdout))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"text/plain; charset=utf-8  819200"}, received)
+}
+
+func TestDryRunJsonRejectsLinesTooLongToReadBack(t *testing.T) {
+	t.Parallel()
+	// the binary file is base64 encoded in the line, a third again as long
+	partFile := filepath.Join(t.TempDir(), "part.bin")
+	os.WriteFile(partFile, make([]byte, 800*1024), 0o600)
+
+	input := `{"url":"http://example.com/1","bodyType":"multipart","body":{"upload":{"file":"` + partFile + `"}}}
+{"url":"http://example.com/2"}`
+
+	runResults, err := RunGanda([]string{"ganda", "--dry-run", "json"}, strings.NewReader(input))
+
+	assert.EqualError(t, err, "unable to print 1 requests")
+	assert.Equal(t, `{"url":"http://example.com/2","method":"GET"}`+"\n", runResults.stdout)
+	assert.Contains(t, runResults.stderr, "byte JSON line is longer than the 1048576 bytes ganda can read, send large bodies with bodyFile")
+	assert.Contains(t, runResults.stderr, "Dry run: 1 requests, 1 that couldn't be printed\n")
+}
+
+func TestDryRunReportsParseErrors(t *testing.T) {
+	t.Parallel()
+	input := `{"url":"http://example.com/1"}
+{"url":"http://example.com/2","bodyType":"yaml","body":"a"}
+{"url":"http://example.com/3"}`
+
+	runResults, err := RunGanda([]string{"ganda", "--dry-run", "curl"}, strings.NewReader(input))
+
+	assert.Error(t, err)
+	assert.Equal(t, "curl -L http://example.com/1\n", runResults.stdout)
+	assert.Contains(t, runResults.stderr, "unsupported body type: yaml")
+	assert.Contains(t, runResults.stderr, "Dry run: 1 requests, stopped at a parse error\n")
+}
@@ -0,0 +1,317 @@
+package cli
+
+import (
+	"bytes"
+	ctx "context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/tednaleid/ganda/execcontext"
+	"github.com/tednaleid/ganda/parser"
+)
+
+// DryRunRequests runs the input through the same parsing as ProcessRequests but prints each
+// request to stdout rather than sending it.  It returns an error if the input couldn't be parsed
+// or a request's body couldn't be read
+func DryRunRequests(runContext ctx.Context, context *execcontext.Context) error {
+	requestsWithContextChannel := make(chan parser.RequestWithContext, context.RequestWorkers)
+
+	dispatchContext, cancelDispatch := ctx.WithCancel(runContext)
+	defer cancelDispatch()
+
+	stopHandlingSignals := handleSignals(context.Logger, cancelDispatch, cancelDispatch)
+	defer stopHandlingSignals()
+
+	if context.Netrc != nil || context.Authenticator != nil || context.Signer != nil || context.CookieJar != nil {
+		context.Logger.Warn("Dry run: credentials and signatures added when sending aren't shown")
+	}
+
+	// a single printer keeps the requests in the order of the input
+	var printed, failed int
+	var printerWaitGroup sync.WaitGroup
+	printerWaitGroup.Add(1)
+	go func() {
+		defer printerWaitGroup.Done()
+		for requestWithContext := range requestsWithContextChannel {
+			line, err := formatDryRun(requestWithContext, context)
+			if err != nil {
+				failed++
+				context.Logger.LogError(err, requestWithContext.Request.URL.Redacted())
+				continue
+			}
+			fmt.Fprintln(context.Out, line)
+			printed++
+		}
+	}()
+
+	err := parser.SendRequests(dispatchContext, requestsWithContextChannel, context.In, context.RequestMethod, context.RequestHeaders, context.RequestBodyEncoding)
+
+	close(requestsWithContextChannel)
+	printerWaitGroup.Wait()
+
+	if err != nil && !errors.Is(err, ctx.Canceled) {
+		context.Logger.LogError(err, "error parsing requests")
+		context.Logger.Info("Dry run: %d requests, stopped at a parse error", printed)
+		return err
+	}
+
+	if failed > 0 {
+		context.Logger.Info("Dry run: %d requests, %d that couldn't be printed", printed, failed)
+		return fmt.Errorf("unable to print %d requests", failed)
+	}
+
+	context.Logger.Info("Dry run: %d requests", printed)
+
+	if errors.Is(err, ctx.Canceled) {
+		return errors.New("interrupted before all input was processed")
+	}
+
+	return nil
+}
+
+// body files are printed as their path rather than read, other bodies are printed inline
+func formatDryRun(requestWithContext parser.RequestWithContext, context *execcontext.Context) (string, error) {
+	var body []byte
+	if requestWithContext.BodyFile == "" {
+		var err error
+		body, err = dryRunBody(requestWithContext.Request)
+		if err != nil {
+			return "", fmt.Errorf("unable to read body: %w", err)
+		}
+	}
+
+	if context.DryRun == "json" {
+		return formatJsonLine(requestWithContext, body, context)
+	}
+	return formatCurl(requestWithContext, body, context), nil
+}
+
+// dryRunBody reads the body the request would send, through GetBody when the body is replayable
+func dryRunBody(request *http.Request) ([]byte, error) {
+	body := request.Body
+	if request.GetBody != nil {
+		var err error
+		body, err = request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if body == nil || body == http.NoBody {
+		return nil, nil
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+// the headers sorted by name, with the values of each in the order they're sent and credentials redacted.
+// The keep-alive Connection header is left out as ganda adds it to every request
+func dryRunHeaders(request *http.Request, context *execcontext.Context) [][2]string {
+	keys := make([]string, 0, len(request.Header))
+	for key := range request.Header {
+		if key != "Connection" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var headers [][2]string
+	for _, key := range keys {
+		for _, value := range request.Header[key] {
+			headers = append(headers, [2]string{key, context.Logger.RedactHeader(key, value)})
+		}
+	}
+	return headers
+}
+
+// formatCurl prints a single line curl command, binary bodies and bodies starting with @ (which
+// curl would read as a filename) are decoded from base64 through bash/zsh process substitution.
+// Body files are read by curl, through gzip or zstd when ganda would compress them
+func formatCurl(requestWithContext parser.RequestWithContext, body []byte, context *execcontext.Context) string {
+	request := requestWithContext.Request
+	overrides := requestWithContext.Overrides
+	if overrides == nil {
+		overrides = &parser.RequestOverrides{}
+	}
+	hasBody := body != nil || requestWithContext.BodyFile != ""
+
+	args := []string{"curl"}
+
+	switch {
+	case request.Method == http.MethodHead:
+		args = append(args, "--head")
+	case request.Method == http.MethodGet && !hasBody:
+	case request.Method == http.MethodPost && hasBody:
+	default:
+		args = append(args, "-X", shellQuote(request.Method))
+	}
+
+	followRedirects := !context.NoFollowRedirects
+	if overrides.FollowRedirects != nil {
+		followRedirects = *overrides.FollowRedirects
+	}
+	if followRedirects {
+		args = append(args, "-L")
+	}
+
+	if context.Insecure {
+		args = append(args, "-k")
+	}
+
+	if overrides.Timeout > 0 {
+		args = append(args, "--max-time", strconv.FormatFloat(overrides.Timeout.Seconds(), 'f', -1, 64))
+	}
+
+	unixSocket := context.UnixSocket
+	if overrides.UnixSocket != "" {
+		unixSocket = overrides.UnixSocket
+	}
+	if unixSocket != "" {
+		args = append(args, "--unix-socket", shellQuote(unixSocket))
+	}
+
+	for _, header := range dryRunHeaders(request, context) {
+		if header[1] == "" {
+			// curl removes a header given as 'Name:', 'Name;' sends it empty
+			args = append(args, "-H", shellQuote(header[0]+";"))
+		} else {
+			args = append(args, "-H", shellQuote(header[0]+": "+header[1]))
+		}
+	}
+
+	if requestWithContext.BodyFile != "" {
+		switch requestWithContext.BodyFileEncoding {
+		case "":
+			args = append(args, "--data-binary", shellQuote("@"+requestWithContext.BodyFile))
+		case "zstd":
+			args = append(args, "--data-binary", "@<(zstd -qc "+shellQuote(requestWithContext.BodyFile)+")")
+		default:
+			args = append(args, "--data-binary", "@<("+requestWithContext.BodyFileEncoding+" -c "+shellQuote(requestWithContext.BodyFile)+")")
+		}
+	} else if body != nil {
+		if isPrintable(body) && !bytes.HasPrefix(body, []byte("@")) {
+			args = append(args, "--data-binary", shellQuote(string(body)))
+		} else {
+			encoded := base64.StdEncoding.EncodeToString(body)
+			args = append(args, "--data-binary", "@<(printf %s "+shellQuote(encoded)+" | base64 -d)")
+		}
+	}
+
+	args = append(args, shellQuote(request.URL.Redacted()))
+
+	return strings.Join(args, " ")
+}
+
+// dryRunJsonLine is a JSON line that ganda reads back as the same request
+type dryRunJsonLine struct {
+	URL             string          `json:"url"`
+	Method          string          `json:"method"`
+	Headers         [][2]string     `json:"headers,omitempty"`
+	Body            json.RawMessage `json:"body,omitempty"`
+	BodyType        string          `json:"bodyType,omitempty"`
+	BodyFile        string          `json:"bodyFile,omitempty"`
+	BodyEncoding    string          `json:"bodyEncoding,omitempty"`
+	Context         interface{}     `json:"context,omitempty"`
+	TimeoutMillis   int64           `json:"timeoutMillis,omitempty"`
+	Retries         *int            `json:"retries,omitempty"`
+	OutputFile      string          `json:"outputFile,omitempty"`
+	FollowRedirects *bool           `json:"followRedirects,omitempty"`
+	UnixSocket      string          `json:"unixSocket,omitempty"`
+}
+
+// formatJsonLine rejects lines too long for ganda to read back, those with a body file only have its path
+func formatJsonLine(requestWithContext parser.RequestWithContext, body []byte, context *execcontext.Context) (string, error) {
+	request := requestWithContext.Request
+
+	line := dryRunJsonLine{
+		URL:     request.URL.Redacted(),
+		Method:  request.Method,
+		Headers: dryRunHeaders(request, context),
+		Context: requestWithContext.RequestContext,
+	}
+
+	if requestWithContext.BodyFile != "" {
+		// the file's compression is given explicitly so it isn't changed by --compress-request-body
+		line.BodyFile = requestWithContext.BodyFile
+		line.BodyEncoding = "identity"
+		if requestWithContext.BodyFileEncoding != "" {
+			line.BodyEncoding = requestWithContext.BodyFileEncoding
+		}
+	} else if body != nil {
+		var err error
+		if isPrintable(body) {
+			line.Body, err = json.Marshal(string(body))
+			line.BodyType = "escaped"
+		} else {
+			line.Body, err = json.Marshal(base64.StdEncoding.EncodeToString(body))
+			line.BodyType = "base64"
+		}
+		if err != nil {
+			return "", err
+		}
+
+		// the body is already compressed, it shouldn't be compressed again when it's read back
+		if request.Header.Get("Content-Encoding") != "" {
+			line.BodyEncoding = "identity"
+		}
+	}
+
+	if overrides := requestWithContext.Overrides; overrides != nil {
+		line.TimeoutMillis = overrides.Timeout.Milliseconds()
+		line.Retries = overrides.Retries
+		line.OutputFile = overrides.OutputFile
+		line.FollowRedirects = overrides.FollowRedirects
+		line.UnixSocket = overrides.UnixSocket
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(line); err != nil {
+		return "", err
+	}
+
+	if buffer.Len() > parser.MaxJsonLineSize {
+		return "", fmt.Errorf("the %d byte JSON line is longer than the %d bytes ganda can read, send large bodies with bodyFile", buffer.Len(), parser.MaxJsonLineSize)
+	}
+
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+// text bodies are printed as is, anything else is base64 encoded
+func isPrintable(body []byte) bool {
+	if !utf8.Valid(body) {
+		return false
+	}
+	for _, r := range string(body) {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// single quotes keep the shell from expanding anything, a single quote is closed, escaped and reopened.
+// Line breaks and tabs use bash/zsh $'...' quoting so each command stays on one line
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@,+%", r))
+	}) < 0 {
+		return s
+	}
+	if strings.ContainsAny(s, "\n\r\t") {
+		return "$'" + ansiCEscaper.Replace(s) + "'"
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+var ansiCEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
@@ -4,41 +4,111 @@ import (
 	"errors"
 	"math"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	BaseDirectory        string
-	BaseRetryDelayMillis int
-	Color                bool
-	ConnectTimeoutMillis int
-	Insecure             bool
-	JsonEnvelope         bool
-	RequestFilename      string
-	RequestHeaders       []RequestHeader
-	RequestMethod        string
-	RequestWorkers       int
-	ResponseWorkers      int
-	ResponseBody         ResponseBodyType
-	Retries              int
-	Silent               bool
-	SubdirLength         int
-	ThrottlePerSecond    int
+	AcceptEncoding          string
+	AuthCommand             string
+	AuthCommandTTL          time.Duration
+	AWSSigV4                string
+	BaseDirectory           string
+	CACertFile              string
+	BaseRetryDelayMillis    int
+	BearerTokenFile         string
+	CertFile                string
+	Color                   bool
+	ConnectionPools         int
+	ConnectTimeoutMillis    int
+	ConnectTo               []string
+	CookieJarFile           string
+	DNSServer               string
+	DryRun                  string
+	FailureWindow           int
+	HTTP1                   bool
+	HTTP2                   bool
+	HTTP2PriorKnowledge     bool
+	IdleConnTimeout         time.Duration
+	Insecure                bool
+	IPv4                    bool
+	IPv6                    bool
+	JsonEnvelope            bool
+	KeyFile                 string
+	LogFormat               string
+	LogLevel                string
+	MaxConnsPerHost         int
+	MaxFailureRate          float64
+	MaxFailures             int
+	MaxIdleConns            int
+	MaxRedirects            int
+	Netrc                   bool
+	NetrcFile               string
+	NoFollowRedirects       bool
+	NoProxy                 string
+	OAuth2ClientId          string
+	OAuth2ClientSecretFile  string
+	OAuth2Scope             string
+	OAuth2TokenUrl          string
+	Proxy                   string
+	RequestBodyEncoding     string
+	RequestFilename         string
+	RequestHeaders          []RequestHeader
+	RequestMethod           string
+	RequestWorkers          int
+	Resolve                 []string
+	ResponseWorkers         int
+	ResponseBody            ResponseBodyType
+	Retries                 int
+	SameHostRedirects       bool
+	SaveCompressed          bool
+	SignHMACAlgorithm       string
+	SignHMACEncoding        string
+	SignHMACHeader          string
+	SignHMACKeyFile         string
+	SignHMACTemplate        string
+	SignHMACTimestampHeader string
+	ServerName              string
+	SourceAddress           string
+	Silent                  bool
+	SubdirLength            int
+	Summary                 bool
+	ThrottlePerSecond       int
+	TLSMinVersion           string
+	UnixSocket              string
+	User                    string
+	Verbose                 int
 }
 
 func New() *Config {
 	return &Config{
-		BaseRetryDelayMillis: 1_000,
-		Color:                false,
-		ConnectTimeoutMillis: 10_000,
-		Insecure:             false,
-		JsonEnvelope:         false,
-		RequestMethod:        "GET",
-		RequestWorkers:       1,
-		ResponseBody:         Raw,
-		Retries:              0,
-		Silent:               false,
-		SubdirLength:         0,
-		ThrottlePerSecond:    math.MaxInt32,
+		AuthCommandTTL:          5 * time.Minute,
+		BaseRetryDelayMillis:    1_000,
+		Color:                   false,
+		ConnectionPools:         1,
+		ConnectTimeoutMillis:    10_000,
+		FailureWindow:           100,
+		IdleConnTimeout:         90 * time.Second,
+		Insecure:                false,
+		JsonEnvelope:            false,
+		LogFormat:               "plain",
+		LogLevel:                "info",
+		MaxFailureRate:          0,
+		MaxConnsPerHost:         0,
+		MaxFailures:             0,
+		MaxIdleConns:            500,
+		MaxRedirects:            10,
+		RequestMethod:           "GET",
+		RequestWorkers:          1,
+		ResponseBody:            Raw,
+		Retries:                 0,
+		Silent:                  false,
+		SignHMACAlgorithm:       "sha256",
+		SignHMACEncoding:        "hex",
+		SignHMACHeader:          "X-Signature",
+		SignHMACTemplate:        `{method}\n{path}\n{timestamp}\n{body_sha256}`,
+		SignHMACTimestampHeader: "X-Timestamp",
+		SubdirLength:            0,
+		ThrottlePerSecond:       math.MaxInt32,
 	}
 }
 
@@ -73,6 +143,18 @@ func ConvertRequestHeaders(stringHeaders []string) ([]RequestHeader, error) {
 	return requestHeaders, nil
 }
 
+// NewCookieHeader combines the 'name=value' cookies into a single Cookie header
+func NewCookieHeader(cookies []string) (RequestHeader, error) {
+	for _, cookie := range cookies {
+		name, _, found := strings.Cut(cookie, "=")
+		if !found || strings.TrimSpace(name) == "" {
+			return RequestHeader{}, errors.New("Cookie should be in the format 'name=value' -> " + cookie)
+		}
+	}
+
+	return RequestHeader{Key: "Cookie", Value: strings.Join(cookies, "; ")}, nil
+}
+
 type ResponseBodyType string
 
 const (
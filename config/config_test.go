@@ -3,6 +3,7 @@ package config
 import (
 	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -10,16 +11,29 @@ import (
 func TestNewDefaults(t *testing.T) {
 	conf := New()
 
+	assert.Equal(t, 5*time.Minute, conf.AuthCommandTTL)
 	assert.Equal(t, 1000, conf.BaseRetryDelayMillis)
 	assert.Equal(t, 10_000, conf.ConnectTimeoutMillis)
+	assert.Equal(t, 1, conf.ConnectionPools)
+	assert.Equal(t, 100, conf.FailureWindow)
+	assert.Equal(t, 90*time.Second, conf.IdleConnTimeout)
+	assert.Equal(t, 0, conf.MaxConnsPerHost)
+	assert.Equal(t, 0, conf.MaxFailures)
+	assert.Equal(t, 500, conf.MaxIdleConns)
+	assert.Equal(t, 10, conf.MaxRedirects)
+	assert.Equal(t, 0.0, conf.MaxFailureRate)
 	assert.Equal(t, false, conf.Color)
 	assert.Equal(t, false, conf.Insecure)
 	assert.Equal(t, false, conf.JsonEnvelope)
+	assert.Equal(t, "plain", conf.LogFormat)
+	assert.Equal(t, "info", conf.LogLevel)
 	assert.Equal(t, "GET", conf.RequestMethod)
 	assert.Equal(t, 1, conf.RequestWorkers)
 	assert.Equal(t, Raw, conf.ResponseBody)
 	assert.Equal(t, 0, conf.Retries)
 	assert.Equal(t, false, conf.Silent)
+	assert.Equal(t, "sha256", conf.SignHMACAlgorithm)
+	assert.Equal(t, "X-Signature", conf.SignHMACHeader)
 	assert.Equal(t, 0, conf.SubdirLength)
 	assert.Equal(t, math.MaxInt32, conf.ThrottlePerSecond)
 }
@@ -83,3 +97,12 @@ func TestResponseBodyTypeValues(t *testing.T) {
 	assert.Equal(t, ResponseBodyType("sha256"), Sha256)
 	assert.Equal(t, ResponseBodyType("raw"), Raw)
 }
+
+func TestNewCookieHeader(t *testing.T) {
+	header, err := NewCookieHeader([]string{"session=abc123", "team=blue=green"})
+	assert.NoError(t, err)
+	assert.Equal(t, RequestHeader{Key: "Cookie", Value: "session=abc123; team=blue=green"}, header)
+
+	_, err = NewCookieHeader([]string{"session"})
+	assert.EqualError(t, err, "Cookie should be in the format 'name=value' -> session")
+}
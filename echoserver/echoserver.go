@@ -7,11 +7,9 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"io"
+	"net"
 	"net/http"
-	"os"
-	"os/signal"
 	"strings"
-	"syscall"
 	"time"
 )
 
@@ -21,6 +19,7 @@ type RequestEcho struct {
 	RemoteIP    string            `json:"remote_ip"`
 	Host        string            `json:"host"`
 	Method      string            `json:"method"`
+	Protocol    string            `json:"protocol"`
 	URI         string            `json:"uri"`
 	UserAgent   string            `json:"user_agent"`
 	Status      int               `json:"status"`
@@ -62,18 +61,25 @@ func Echoserver(port int, delayMillis int, out io.Writer) (func() error, error)
 		WriteTimeout: 5 * time.Minute,
 	}
 
+	// also serve cleartext HTTP/2 (h2c) to clients with prior knowledge, ex: ganda --http2-prior-knowledge
+	s.Protocols = new(http.Protocols)
+	s.Protocols.SetHTTP1(true)
+	s.Protocols.SetUnencryptedHTTP2(true)
+
+	// listen before returning so callers can make requests as soon as we return
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return nil, err
+	}
+	e.Listener = listener
+
 	go func() {
 		if err := e.StartServer(s); err != nil && err != http.ErrServerClosed {
 			e.Logger.Fatal(err)
 		}
 	}()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-
 	shutdown := func() error {
-		signal.Stop(quit)
-		close(quit)
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		return s.Shutdown(ctx)
@@ -90,6 +96,7 @@ func requestToJSON(c echo.Context, reqBody []byte) []byte {
 		RemoteIP:    c.RealIP(),
 		Host:        c.Request().Host,
 		Method:      c.Request().Method,
+		Protocol:    c.Request().Proto,
 		URI:         c.Request().RequestURI,
 		UserAgent:   c.Request().UserAgent(),
 		Status:      c.Response().Status,
@@ -134,3 +134,35 @@ func TestEchoserverPOST(t *testing.T) {
 		}
 	})
 }
+
+func TestEchoserverH2C(t *testing.T) {
+	withEchoserver(t, func(port int) {
+		transport := &http.Transport{Protocols: new(http.Protocols)}
+		transport.Protocols.SetUnencryptedHTTP2(true)
+		client := &http.Client{Transport: transport}
+
+		resp, err := client.Get("http://localhost:" + strconv.Itoa(port) + "/foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.Proto != "HTTP/2.0" {
+			t.Errorf("expected protocol 'HTTP/2.0', got '%s'", resp.Proto)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var logEntry RequestEcho
+		if err := json.Unmarshal(body, &logEntry); err != nil {
+			t.Fatalf("failed to unmarshal response body: %v", err)
+		}
+
+		if logEntry.Protocol != "HTTP/2.0" {
+			t.Errorf("expected protocol 'HTTP/2.0', got '%s'", logEntry.Protocol)
+		}
+	})
+}
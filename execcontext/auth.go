@@ -0,0 +1,296 @@
+package execcontext
+
+import (
+	"bufio"
+	ctx "context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tednaleid/ganda/config"
+)
+
+const (
+	headerEnvPrefix  = "@env:"
+	headerFilePrefix = "@file:"
+)
+
+// resolveHeaderValues replaces '@env:NAME' and '@file:PATH' header values with the environment
+// variable or the trimmed file contents so secrets stay out of shell history and process listings.
+// The names of the headers that were resolved are returned so their values can be redacted
+func resolveHeaderValues(headers []config.RequestHeader) ([]config.RequestHeader, []string, error) {
+	var resolved []config.RequestHeader
+	var secretHeaders []string
+
+	for _, header := range headers {
+		switch {
+		case strings.HasPrefix(header.Value, headerEnvPrefix):
+			name := strings.TrimPrefix(header.Value, headerEnvPrefix)
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				return nil, nil, fmt.Errorf("the %s header's environment variable %s is not set", header.Key, name)
+			}
+			header.Value = value
+			secretHeaders = append(secretHeaders, header.Key)
+		case strings.HasPrefix(header.Value, headerFilePrefix):
+			value, err := readSecretFile(strings.TrimPrefix(header.Value, headerFilePrefix))
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to read the %s header's value: %w", header.Key, err)
+			}
+			header.Value = value
+			secretHeaders = append(secretHeaders, header.Key)
+		}
+		resolved = append(resolved, header)
+	}
+
+	return resolved, secretHeaders, nil
+}
+
+// authorizationHeader returns the Authorization header for --user or --bearer-token-file,
+// ok is false when neither is given.  Only one way of authenticating can be used
+func authorizationHeader(conf *config.Config) (config.RequestHeader, bool, error) {
+	authFlags := 0
+	for _, flag := range []string{conf.User, conf.BearerTokenFile, conf.OAuth2TokenUrl, conf.AuthCommand, conf.AWSSigV4} {
+		if flag != "" {
+			authFlags++
+		}
+	}
+	if authFlags > 1 {
+		return config.RequestHeader{}, false, errors.New("only one of --user, --bearer-token-file, --oauth2-token-url, --auth-command and --aws-sigv4 can be used")
+	}
+
+	if conf.User != "" {
+		if !strings.Contains(conf.User, ":") {
+			return config.RequestHeader{}, false, errors.New("invalid user value, expected user:password")
+		}
+		credentials := base64.StdEncoding.EncodeToString([]byte(conf.User))
+		return config.RequestHeader{Key: "Authorization", Value: "Basic " + credentials}, true, nil
+	}
+
+	if conf.BearerTokenFile != "" {
+		token, err := readSecretFile(conf.BearerTokenFile)
+		if err != nil {
+			return config.RequestHeader{}, false, fmt.Errorf("unable to read --bearer-token-file: %w", err)
+		}
+		if token == "" {
+			return config.RequestHeader{}, false, fmt.Errorf("--bearer-token-file %s is empty", conf.BearerTokenFile)
+		}
+		return config.RequestHeader{Key: "Authorization", Value: "Bearer " + token}, true, nil
+	}
+
+	return config.RequestHeader{}, false, nil
+}
+
+// secrets are commonly saved with a trailing newline
+func readSecretFile(filename string) (string, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+func hasHeader(headers []config.RequestHeader, key string) bool {
+	for _, header := range headers {
+		if strings.EqualFold(header.Key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Netrc holds the per-host credentials from a .netrc file, they're sent as basic auth
+// to requests that don't already have an Authorization header
+type Netrc struct {
+	machines           map[string]netrcCredentials // by lowercase host
+	defaultCredentials *netrcCredentials
+}
+
+type netrcCredentials struct {
+	login    string
+	password string
+}
+
+// newNetrc loads --netrc-file, or ~/.netrc for --netrc, which is ignored if it doesn't exist
+func newNetrc(conf *config.Config) (*Netrc, error) {
+	filename := conf.NetrcFile
+	if filename == "" {
+		if !conf.Netrc {
+			return nil, nil
+		}
+
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the home directory for --netrc: %w", err)
+		}
+		filename = filepath.Join(home, ".netrc")
+		if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read netrc file: %w", err)
+	}
+	defer file.Close()
+
+	return parseNetrc(bufio.NewScanner(file), filename)
+}
+
+// Lookup returns the credentials for the host, falling back to the file's default entry
+func (n *Netrc) Lookup(host string) (login string, password string, ok bool) {
+	if credentials, found := n.machines[strings.ToLower(host)]; found {
+		return credentials.login, credentials.password, true
+	}
+	if n.defaultCredentials != nil {
+		return n.defaultCredentials.login, n.defaultCredentials.password, true
+	}
+	return "", "", false
+}
+
+// the tokens can be spread over any number of lines, macdef bodies run until a blank line.
+// The first entry for a machine wins, like curl
+func parseNetrc(scanner *bufio.Scanner, filename string) (*Netrc, error) {
+	netrc := &Netrc{machines: make(map[string]netrcCredentials)}
+
+	var tokens []string
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			if strings.HasPrefix(field, "#") {
+				break
+			}
+			tokens = append(tokens, field)
+			if field == "macdef" {
+				// the macro's name is the rest of the line, its body is on the lines that follow
+				inMacro = true
+				if i+1 < len(fields) {
+					tokens = append(tokens, fields[i+1])
+				}
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read netrc file: %w", err)
+	}
+
+	var current *netrcCredentials
+	var currentMachine string
+	finish := func() {
+		if current == nil {
+			return
+		}
+		if currentMachine == "" {
+			if netrc.defaultCredentials == nil {
+				netrc.defaultCredentials = current
+			}
+		} else if _, found := netrc.machines[currentMachine]; !found {
+			netrc.machines[currentMachine] = *current
+		}
+		current = nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch token {
+		case "default":
+			finish()
+			current, currentMachine = &netrcCredentials{}, ""
+			continue
+		case "machine", "login", "password", "account", "macdef":
+		default:
+			return nil, fmt.Errorf("invalid netrc file %s: unexpected token %q", filename, token)
+		}
+
+		if i+1 >= len(tokens) {
+			return nil, fmt.Errorf("invalid netrc file %s: missing value for %s", filename, token)
+		}
+		i++
+		value := tokens[i]
+
+		switch token {
+		case "machine":
+			finish()
+			current, currentMachine = &netrcCredentials{}, strings.ToLower(value)
+		case "login", "password":
+			if current == nil {
+				return nil, fmt.Errorf("invalid netrc file %s: %s before machine or default", filename, token)
+			}
+			if token == "login" {
+				current.login = value
+			} else {
+				current.password = value
+			}
+		}
+	}
+	finish()
+
+	return netrc, nil
+}
+
+// Authenticator supplies the Authorization header for each request, it's shared by every
+// request worker.  Invalidate is called with the header when a request using it gets a 401
+// so the next call to Authorization gets a new one
+type Authenticator interface {
+	Authorization(ctx.Context) (string, error)
+	Invalidate(authorization string)
+}
+
+// cachingAuthenticator reuses the Authorization header from fetch until its refresh time (a zero
+// time never expires) or until it's invalidated.  Workers wait for a fetch in progress rather
+// than each fetching their own
+type cachingAuthenticator struct {
+	fetch func(ctx.Context) (authorization string, refreshAt time.Time, err error)
+
+	mutex         sync.Mutex
+	authorization string
+	refreshAt     time.Time
+}
+
+func (a *cachingAuthenticator) Authorization(requestContext ctx.Context) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.authorization != "" && (a.refreshAt.IsZero() || time.Now().Before(a.refreshAt)) {
+		return a.authorization, nil
+	}
+
+	authorization, refreshAt, err := a.fetch(requestContext)
+	if err != nil {
+		return "", err
+	}
+
+	a.authorization, a.refreshAt = authorization, refreshAt
+	return a.authorization, nil
+}
+
+// Invalidate drops the header unless another worker has already replaced it
+func (a *cachingAuthenticator) Invalidate(authorization string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.authorization == authorization {
+		a.authorization = ""
+	}
+}
+
+// Signer adds headers signing the request, it's called just before each attempt is sent so
+// retries get a fresh signature
+type Signer interface {
+	Sign(request *http.Request) error
+}
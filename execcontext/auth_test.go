@@ -0,0 +1,121 @@
+package execcontext
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tednaleid/ganda/config"
+)
+
+func TestParseNetrc(t *testing.T) {
+	netrc, err := parseNetrc(bufio.NewScanner(strings.NewReader(`
+# a comment
+machine api.example.com login alice password secret
+machine other.example.com
+  login bob
+  password hunter2 # trailing comment
+machine API.example.com login ignored password ignored
+
+macdef init
+cd /pub
+login notused password notused
+
+default login anonymous password guest
+`)), "netrc")
+	assert.NoError(t, err)
+
+	login, password, ok := netrc.Lookup("api.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "alice", login)
+	assert.Equal(t, "secret", password)
+
+	login, password, _ = netrc.Lookup("OTHER.example.com")
+	assert.Equal(t, "bob", login)
+	assert.Equal(t, "hunter2", password)
+
+	login, password, ok = netrc.Lookup("unknown.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "anonymous", login)
+	assert.Equal(t, "guest", password)
+}
+
+func TestParseNetrcWithoutDefault(t *testing.T) {
+	netrc, err := parseNetrc(bufio.NewScanner(strings.NewReader("machine api.example.com login alice password secret")), "netrc")
+	assert.NoError(t, err)
+
+	_, _, ok := netrc.Lookup("unknown.example.com")
+	assert.False(t, ok)
+}
+
+func TestParseNetrcErrors(t *testing.T) {
+	_, err := parseNetrc(bufio.NewScanner(strings.NewReader("login alice")), "netrc")
+	assert.EqualError(t, err, "invalid netrc file netrc: login before machine or default")
+
+	_, err = parseNetrc(bufio.NewScanner(strings.NewReader("machine api.example.com login")), "netrc")
+	assert.EqualError(t, err, "invalid netrc file netrc: missing value for login")
+
+	_, err = parseNetrc(bufio.NewScanner(strings.NewReader("machine api.example.com user alice")), "netrc")
+	assert.EqualError(t, err, `invalid netrc file netrc: unexpected token "user"`)
+}
+
+func TestNetrcDefaultFileIsOptional(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	conf := config.New()
+	conf.Netrc = true
+	context, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+	assert.Nil(t, context.Netrc)
+
+	conf.NetrcFile = filepath.Join(t.TempDir(), "missing")
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.ErrorContains(t, err, "unable to read netrc file")
+}
+
+func TestAuthorizationHeader(t *testing.T) {
+	conf := config.New()
+	conf.User = "alice:secret"
+	context, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.RequestHeader{{Key: "Authorization", Value: "Basic YWxpY2U6c2VjcmV0"}}, context.RequestHeaders)
+
+	// an Authorization header given with --header takes precedence
+	conf.RequestHeaders = []config.RequestHeader{{Key: "authorization", Value: "Token abc"}}
+	context, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.RequestHeader{{Key: "authorization", Value: "Token abc"}}, context.RequestHeaders)
+
+	conf = config.New()
+	conf.User = "alice"
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "invalid user value, expected user:password")
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("  \n"), 0o600)
+	conf = config.New()
+	conf.BearerTokenFile = tokenFile
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "--bearer-token-file "+tokenFile+" is empty")
+
+	conf.User = "alice:secret"
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "only one of --user, --bearer-token-file, --oauth2-token-url, --auth-command and --aws-sigv4 can be used")
+}
+
+func TestResolvedHeadersAreRedacted(t *testing.T) {
+	t.Setenv("GANDA_TEST_API_KEY", "env-key")
+
+	conf := config.New()
+	conf.RequestHeaders = []config.RequestHeader{{Key: "x-api-key", Value: "@env:GANDA_TEST_API_KEY"}, {Key: "Accept", Value: "text/plain"}}
+	context, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "env-key", context.RequestHeaders[0].Value)
+	assert.Equal(t, "[REDACTED]", context.Logger.RedactHeader("X-Api-Key", "env-key"))
+	assert.Equal(t, "text/plain", context.Logger.RedactHeader("Accept", "text/plain"))
+}
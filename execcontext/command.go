@@ -0,0 +1,62 @@
+package execcontext
+
+import (
+	"bytes"
+	ctx "context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// newCommandAuthenticator runs the --auth-command with the shell and uses its output as the
+// Authorization header until the ttl passes (0 keeps it until a request gets a 401), output
+// without a scheme, like a bare token, is sent as a bearer token
+func newCommandAuthenticator(command string, ttl time.Duration) *cachingAuthenticator {
+	return &cachingAuthenticator{
+		fetch: func(requestContext ctx.Context) (string, time.Time, error) {
+			authorization, err := runAuthCommand(requestContext, command)
+			if err != nil {
+				return "", time.Time{}, err
+			}
+
+			var refreshAt time.Time
+			if ttl > 0 {
+				refreshAt = time.Now().Add(ttl)
+			}
+			return authorization, refreshAt, nil
+		},
+	}
+}
+
+func runAuthCommand(commandContext ctx.Context, command string) (string, error) {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(commandContext, shell, flag, command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("--auth-command failed: %w: %s", err, message)
+		}
+		return "", fmt.Errorf("--auth-command failed: %w", err)
+	}
+
+	authorization := strings.TrimSpace(stdout.String())
+	if authorization == "" {
+		return "", errors.New("--auth-command didn't output anything")
+	}
+
+	if !strings.Contains(authorization, " ") {
+		authorization = "Bearer " + authorization
+	}
+
+	return authorization, nil
+}
@@ -0,0 +1,70 @@
+package execcontext
+
+import (
+	ctx "context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tednaleid/ganda/config"
+)
+
+func TestAuthCommandOutputIsCachedForTTL(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "counter")
+
+	conf := config.New()
+	conf.AuthCommand = "echo x >> " + counter + " && echo token-$(wc -l < " + counter + " | tr -d ' ')"
+	context, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+
+	authorization, err := context.Authenticator.Authorization(ctx.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer token-1", authorization)
+
+	authorization, _ = context.Authenticator.Authorization(ctx.Background())
+	assert.Equal(t, "Bearer token-1", authorization)
+
+	authenticator := context.Authenticator.(*cachingAuthenticator)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), authenticator.refreshAt, 5*time.Second)
+
+	authenticator.refreshAt = time.Now().Add(-time.Second)
+	authorization, _ = context.Authenticator.Authorization(ctx.Background())
+	assert.Equal(t, "Bearer token-2", authorization)
+
+	context.Authenticator.Invalidate(authorization)
+	authorization, _ = context.Authenticator.Authorization(ctx.Background())
+	assert.Equal(t, "Bearer token-3", authorization)
+}
+
+func TestAuthCommandOutputWithScheme(t *testing.T) {
+	conf := config.New()
+	conf.AuthCommand = "printf 'Basic YWxpY2U6c2VjcmV0\n'"
+	conf.AuthCommandTTL = 0
+	context, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+
+	authorization, err := context.Authenticator.Authorization(ctx.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Basic YWxpY2U6c2VjcmV0", authorization)
+	assert.True(t, context.Authenticator.(*cachingAuthenticator).refreshAt.IsZero())
+}
+
+func TestAuthCommandErrors(t *testing.T) {
+	_, err := runAuthCommand(ctx.Background(), "echo 'permission denied' >&2; exit 2")
+	assert.EqualError(t, err, "--auth-command failed: exit status 2: permission denied")
+
+	_, err = runAuthCommand(ctx.Background(), "true")
+	assert.EqualError(t, err, "--auth-command didn't output anything")
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("token"), 0o600)
+	conf := config.New()
+	conf.AuthCommand = "cat " + tokenFile
+	conf.BearerTokenFile = tokenFile
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "only one of --user, --bearer-token-file, --oauth2-token-url, --auth-command and --aws-sigv4 can be used")
+}
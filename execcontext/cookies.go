@@ -0,0 +1,253 @@
+package execcontext
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// CookieJar is shared by every request worker's client, it's loaded from a Netscape format
+// cookie file (the format curl and wget use) and the cookies it holds are saved back to it.
+// The standard library jar can't list its cookies, so the ones it accepts are also tracked here
+type CookieJar struct {
+	jar      *cookiejar.Jar
+	filename string
+	mutex    sync.Mutex
+	cookies  map[string]jarCookie // by domain, path and name
+}
+
+type jarCookie struct {
+	domain            string
+	includeSubdomains bool
+	path              string
+	secure            bool
+	httpOnly          bool
+	expires           time.Time // zero for session cookies
+	name              string
+	value             string
+}
+
+func (c jarCookie) key() string {
+	return c.domain + ";" + c.path + ";" + c.name
+}
+
+func (c jarCookie) url() *url.URL {
+	scheme := "http"
+	if c.secure {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: c.domain, Path: c.path}
+}
+
+// NewCookieJar loads the cookies from the file if it exists
+func NewCookieJar(filename string) (*CookieJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+
+	cookieJar := &CookieJar{jar: jar, filename: filename, cookies: make(map[string]jarCookie)}
+
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return cookieJar, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read --cookie-jar: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		cookie, ok, err := parseNetscapeCookie(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookie on line %d of %s: %w", lineNumber, filename, err)
+		}
+
+		if ok && (cookie.expires.IsZero() || cookie.expires.After(time.Now())) {
+			cookieJar.SetCookies(cookie.url(), []*http.Cookie{cookie.httpCookie()})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read --cookie-jar: %w", err)
+	}
+
+	return cookieJar, nil
+}
+
+// SetCookies implements http.CookieJar
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for _, cookie := range cookies {
+		tracked := newJarCookie(u, cookie)
+
+		if cookie.MaxAge < 0 || (!tracked.expires.IsZero() && !tracked.expires.After(time.Now())) {
+			delete(j.cookies, tracked.key())
+		} else if j.accepted(tracked) {
+			j.cookies[tracked.key()] = tracked
+		}
+	}
+}
+
+// Cookies implements http.CookieJar
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// the jar rejects cookies for other domains, only track the ones it will send back.  The jar is
+// asked for the cookie's own domain and path, a cookie can be set for a path the response's url isn't on
+func (j *CookieJar) accepted(cookie jarCookie) bool {
+	for _, jarCookie := range j.jar.Cookies(cookie.url()) {
+		if jarCookie.Name == cookie.name && jarCookie.Value == cookie.value {
+			return true
+		}
+	}
+	return false
+}
+
+// Save writes the unexpired cookies back to the file
+func (j *CookieJar) Save() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	var lines []string
+	for _, cookie := range j.cookies {
+		if cookie.expires.IsZero() || cookie.expires.After(time.Now()) {
+			lines = append(lines, cookie.netscapeLine())
+		}
+	}
+	sort.Strings(lines)
+
+	contents := "# Netscape HTTP Cookie File\n# This file was generated by ganda, edit at your own risk\n\n" + strings.Join(lines, "")
+
+	if err := os.WriteFile(j.filename, []byte(contents), 0o600); err != nil {
+		return fmt.Errorf("unable to save --cookie-jar: %w", err)
+	}
+	return nil
+}
+
+func newJarCookie(u *url.URL, cookie *http.Cookie) jarCookie {
+	tracked := jarCookie{
+		domain:   strings.ToLower(u.Hostname()),
+		path:     cookie.Path,
+		secure:   cookie.Secure,
+		httpOnly: cookie.HttpOnly,
+		name:     cookie.Name,
+		value:    cookie.Value,
+	}
+
+	if cookie.Domain != "" {
+		tracked.domain = strings.ToLower(strings.TrimPrefix(cookie.Domain, "."))
+		tracked.includeSubdomains = true
+	}
+
+	// the default path is the directory of the request path
+	if tracked.path == "" || !strings.HasPrefix(tracked.path, "/") {
+		tracked.path = "/"
+		if dir := path.Dir(u.EscapedPath()); strings.HasPrefix(dir, "/") {
+			tracked.path = dir
+		}
+	}
+
+	if cookie.MaxAge > 0 {
+		tracked.expires = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second)
+	} else if !cookie.Expires.IsZero() {
+		tracked.expires = cookie.Expires
+	}
+
+	return tracked
+}
+
+func (c jarCookie) httpCookie() *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     c.name,
+		Value:    c.value,
+		Path:     c.path,
+		Secure:   c.secure,
+		HttpOnly: c.httpOnly,
+		Expires:  c.expires,
+	}
+	if c.includeSubdomains {
+		cookie.Domain = c.domain
+	}
+	return cookie
+}
+
+// domain, include subdomains, path, secure, expires (unix seconds, 0 for session cookies), name, value
+func (c jarCookie) netscapeLine() string {
+	domain := c.domain
+	if c.includeSubdomains {
+		domain = "." + domain
+	}
+	if c.httpOnly {
+		domain = "#HttpOnly_" + domain
+	}
+
+	var expires int64
+	if !c.expires.IsZero() {
+		expires = c.expires.Unix()
+	}
+
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+		domain, netscapeBool(c.includeSubdomains), c.path, netscapeBool(c.secure), expires, c.name, c.value)
+}
+
+// returns false for blank lines and comments
+func parseNetscapeCookie(line string) (jarCookie, bool, error) {
+	var cookie jarCookie
+
+	line = strings.TrimRight(line, "\r")
+	if strings.HasPrefix(line, "#HttpOnly_") {
+		cookie.httpOnly = true
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+	}
+
+	if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+		return cookie, false, nil
+	}
+
+	fields := strings.Split(line, "\t")
+	if len(fields) != 7 {
+		return cookie, false, fmt.Errorf("expected 7 tab separated fields but found %d", len(fields))
+	}
+
+	expires, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return cookie, false, fmt.Errorf("invalid expires %q", fields[4])
+	}
+	if expires > 0 {
+		cookie.expires = time.Unix(expires, 0)
+	}
+
+	cookie.domain = strings.ToLower(strings.TrimPrefix(fields[0], "."))
+	cookie.includeSubdomains = strings.EqualFold(fields[1], "TRUE")
+	cookie.path = fields[2]
+	cookie.secure = strings.EqualFold(fields[3], "TRUE")
+	cookie.name = fields[5]
+	cookie.value = fields[6]
+
+	return cookie, true, nil
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
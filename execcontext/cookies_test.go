@@ -0,0 +1,80 @@
+package execcontext
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookieJarLoadsNetscapeFile(t *testing.T) {
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	filename := filepath.Join(t.TempDir(), "cookies.txt")
+	os.WriteFile(filename, []byte("# Netscape HTTP Cookie File\n\n"+
+		".example.com\tTRUE\t/\tFALSE\t"+future+"\tsession\tabc123\n"+
+		"#HttpOnly_api.example.com\tFALSE\t/v1\tTRUE\t0\ttoken\txyz\n"+
+		"api.example.com\tFALSE\t/\tFALSE\t1\texpired\told\n"), 0o600)
+
+	jar, err := NewCookieJar(filename)
+	assert.NoError(t, err)
+
+	apiUrl, _ := url.Parse("https://api.example.com/v1/items")
+	assert.ElementsMatch(t, []string{"session=abc123", "token=xyz"}, cookieStrings(jar.Cookies(apiUrl)))
+
+	otherUrl, _ := url.Parse("http://www.example.com/")
+	assert.Equal(t, []string{"session=abc123"}, cookieStrings(jar.Cookies(otherUrl)))
+}
+
+func TestCookieJarSavesCookies(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cookies.txt")
+
+	jar, err := NewCookieJar(filename)
+	assert.NoError(t, err)
+
+	loginUrl, _ := url.Parse("http://auth.example.com/login/form")
+	expires := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+	jar.SetCookies(loginUrl, []*http.Cookie{
+		{Name: "session", Value: "abc123", Domain: "example.com", Path: "/", Expires: expires, HttpOnly: true},
+		{Name: "csrf", Value: "token"},
+		{Name: "rejected", Value: "other domain", Domain: "other.com"},
+	})
+	jar.SetCookies(loginUrl, []*http.Cookie{{Name: "csrf", Value: "", MaxAge: -1}})
+	jar.SetCookies(loginUrl, []*http.Cookie{{Name: "flash", Value: "hello"}})
+	jar.SetCookies(loginUrl, []*http.Cookie{{Name: "apikey", Value: "k1", Path: "/api"}})
+
+	assert.NoError(t, jar.Save())
+
+	contents, _ := os.ReadFile(filename)
+	assert.Equal(t, "# Netscape HTTP Cookie File\n# This file was generated by ganda, edit at your own risk\n\n"+
+		"#HttpOnly_.example.com\tTRUE\t/\tFALSE\t"+strconv.FormatInt(expires.Unix(), 10)+"\tsession\tabc123\n"+
+		"auth.example.com\tFALSE\t/api\tFALSE\t0\tapikey\tk1\n"+
+		"auth.example.com\tFALSE\t/login\tFALSE\t0\tflash\thello\n", string(contents))
+
+	reloaded, err := NewCookieJar(filename)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"flash=hello", "session=abc123"}, cookieStrings(reloaded.Cookies(loginUrl)))
+
+	apiUrl, _ := url.Parse("http://auth.example.com/api/items")
+	assert.ElementsMatch(t, []string{"apikey=k1", "session=abc123"}, cookieStrings(reloaded.Cookies(apiUrl)))
+}
+
+func TestCookieJarInvalidFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cookies.txt")
+	os.WriteFile(filename, []byte("# comment\nexample.com\tFALSE\t/\n"), 0o600)
+
+	_, err := NewCookieJar(filename)
+	assert.EqualError(t, err, "invalid cookie on line 2 of "+filename+": expected 7 tab separated fields but found 3")
+}
+
+func cookieStrings(cookies []*http.Cookie) []string {
+	var strings []string
+	for _, cookie := range cookies {
+		strings = append(strings, cookie.String())
+	}
+	return strings
+}
@@ -0,0 +1,226 @@
+package execcontext
+
+import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/tednaleid/ganda/config"
+)
+
+// Dialer opens the connections for every request worker's transport, it applies the curl-style
+// --connect-to and --resolve overrides, the --dns-server resolver, the --ipv4/--ipv6 address
+// family and binds each connection to the next --source-address.  Only where the connection
+// goes changes, the Host header and TLS server name still come from the url
+type Dialer struct {
+	dialer          *net.Dialer
+	network         string              // tcp, tcp4 or tcp6
+	resolve         map[string][]string // lowercase host:port -> ip addresses to dial instead of resolving the host
+	connectTo       []connectTo
+	sourceAddresses []net.IP
+	nextSource      atomic.Uint32
+}
+
+// --connect-to HOST1:PORT1:HOST2:PORT2, an empty HOST1 or PORT1 matches anything and an empty
+// HOST2 or PORT2 keeps the original value
+type connectTo struct {
+	fromHost string
+	fromPort string
+	toHost   string
+	toPort   string
+}
+
+func newDialer(conf *config.Config) (*Dialer, error) {
+	dialer := &Dialer{
+		dialer:  &net.Dialer{},
+		network: "tcp",
+		resolve: make(map[string][]string, len(conf.Resolve)),
+	}
+
+	if conf.IPv4 && conf.IPv6 {
+		return nil, errors.New("--ipv4 and --ipv6 can't be used together")
+	} else if conf.IPv4 {
+		dialer.network = "tcp4"
+	} else if conf.IPv6 {
+		dialer.network = "tcp6"
+	}
+
+	for _, resolve := range conf.Resolve {
+		hostPort, addresses, err := parseResolve(resolve)
+		if err != nil {
+			return nil, err
+		}
+		dialer.resolve[hostPort] = addresses
+	}
+
+	for _, value := range conf.ConnectTo {
+		rule, err := parseConnectTo(value)
+		if err != nil {
+			return nil, err
+		}
+		dialer.connectTo = append(dialer.connectTo, rule)
+	}
+
+	for _, address := range strings.Split(conf.SourceAddress, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source-address value: %s is not an ip address", address)
+		}
+		dialer.sourceAddresses = append(dialer.sourceAddresses, ip)
+	}
+
+	if conf.DNSServer != "" {
+		resolver, err := newResolver(conf.DNSServer)
+		if err != nil {
+			return nil, err
+		}
+		dialer.dialer.Resolver = resolver
+	}
+
+	return dialer, nil
+}
+
+// DialContext is used as the transport's DialContext
+func (d *Dialer) DialContext(dialContext ctx.Context, network string, address string) (net.Conn, error) {
+	address = d.applyConnectTo(address)
+
+	if network == "tcp" {
+		network = d.network
+	}
+
+	dialer := d.dialer
+	if len(d.sourceAddresses) > 0 {
+		dialer, network = d.sourceDialer(network)
+	}
+
+	addresses, ok := d.resolve[strings.ToLower(address)]
+	if !ok {
+		return dialer.DialContext(dialContext, network, address)
+	}
+
+	_, port, _ := net.SplitHostPort(address)
+
+	// like the resolver, try each address until one connects
+	var err error
+	for _, ip := range addresses {
+		var conn net.Conn
+		conn, err = dialer.DialContext(dialContext, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// BindsSourceAddress is true when connections are made from the --source-address addresses
+func (d *Dialer) BindsSourceAddress() bool {
+	return len(d.sourceAddresses) > 0
+}
+
+// sourceDialer binds to the next source address round-robin, a connection can only be made to
+// an address of the same family so the network is narrowed to match it
+func (d *Dialer) sourceDialer(network string) (*net.Dialer, string) {
+	ip := d.sourceAddresses[int(d.nextSource.Add(1)-1)%len(d.sourceAddresses)]
+
+	dialer := *d.dialer
+	dialer.LocalAddr = &net.TCPAddr{IP: ip}
+
+	if network == "tcp" {
+		if ip.To4() != nil {
+			network = "tcp4"
+		} else {
+			network = "tcp6"
+		}
+	}
+
+	return &dialer, network
+}
+
+// the first matching --connect-to rule is used
+func (d *Dialer) applyConnectTo(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+
+	for _, rule := range d.connectTo {
+		if (rule.fromHost == "" || strings.EqualFold(rule.fromHost, host)) && (rule.fromPort == "" || rule.fromPort == port) {
+			if rule.toHost != "" {
+				host = rule.toHost
+			}
+			if rule.toPort != "" {
+				port = rule.toPort
+			}
+			return net.JoinHostPort(host, port)
+		}
+	}
+
+	return address
+}
+
+// --resolve host:port:address[,address...], ipv6 addresses can be in brackets
+func parseResolve(value string) (string, []string, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", nil, fmt.Errorf("invalid resolve value: %s, expected host:port:address", value)
+	}
+
+	var addresses []string
+	for _, address := range strings.Split(parts[2], ",") {
+		address = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(address), "["), "]")
+		if net.ParseIP(address) == nil {
+			return "", nil, fmt.Errorf("invalid resolve value: %s, %q is not an ip address", value, address)
+		}
+		addresses = append(addresses, address)
+	}
+
+	return strings.ToLower(net.JoinHostPort(parts[0], parts[1])), addresses, nil
+}
+
+func parseConnectTo(value string) (connectTo, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 {
+		return connectTo{}, fmt.Errorf("invalid connect-to value: %s, expected HOST1:PORT1:HOST2:PORT2", value)
+	}
+
+	toHost, toPort, err := net.SplitHostPort(parts[2])
+	if err != nil {
+		return connectTo{}, fmt.Errorf("invalid connect-to value: %s, expected HOST1:PORT1:HOST2:PORT2", value)
+	}
+
+	return connectTo{fromHost: parts[0], fromPort: parts[1], toHost: toHost, toPort: toPort}, nil
+}
+
+// newResolver sends DNS queries to the comma separated servers in turn, the port defaults to 53
+func newResolver(dnsServers string) (*net.Resolver, error) {
+	var servers []string
+	for _, server := range strings.Split(dnsServers, ",") {
+		server = strings.TrimSpace(server)
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(server, "["), "]"), "53")
+		}
+
+		host, _, _ := net.SplitHostPort(server)
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid dns-server value: %s, expected ip:port", server)
+		}
+		servers = append(servers, server)
+	}
+
+	var next atomic.Uint32
+	dialer := &net.Dialer{}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(dialContext ctx.Context, network string, _ string) (net.Conn, error) {
+			server := servers[int(next.Add(1)-1)%len(servers)]
+			return dialer.DialContext(dialContext, network, server)
+		},
+	}, nil
+}
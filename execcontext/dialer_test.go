@@ -0,0 +1,116 @@
+package execcontext
+
+import (
+	ctx "context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tednaleid/ganda/config"
+)
+
+func TestDialerResolve(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	conf := config.New()
+	conf.Resolve = []string{"API.example.com:" + port + ":[::1],127.0.0.1"}
+	conf.IPv4 = true
+	context, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+
+	// ::1 can't be dialed with --ipv4, so the dialer falls back to the next address
+	conn, err := context.Dialer.DialContext(ctx.Background(), "tcp", "api.example.com:"+port)
+	if assert.NoError(t, err) {
+		assert.Equal(t, listener.Addr().String(), conn.RemoteAddr().String())
+		conn.Close()
+	}
+}
+
+func TestDialerConnectTo(t *testing.T) {
+	conf := config.New()
+	conf.ConnectTo = []string{"api.example.com:443:canary.example.com:8443", ":80::8080", "other.example.com::[::1]:"}
+	context, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "canary.example.com:8443", context.Dialer.applyConnectTo("API.example.com:443"))
+	assert.Equal(t, "api.example.com:8080", context.Dialer.applyConnectTo("api.example.com:80"))
+	assert.Equal(t, "[::1]:443", context.Dialer.applyConnectTo("other.example.com:443"))
+	assert.Equal(t, "api.example.com:8443", context.Dialer.applyConnectTo("api.example.com:8443"))
+}
+
+func TestDialerNetwork(t *testing.T) {
+	context, err := New(config.New(), strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp", context.Dialer.network)
+
+	conf := config.New()
+	conf.IPv6 = true
+	context, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp6", context.Dialer.network)
+
+	conf.IPv4 = true
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "--ipv4 and --ipv6 can't be used together")
+}
+
+func TestDialerErrors(t *testing.T) {
+	testCases := []struct {
+		update   func(conf *config.Config)
+		expected string
+	}{
+		{func(conf *config.Config) { conf.Resolve = []string{"api.example.com:443"} }, "invalid resolve value: api.example.com:443, expected host:port:address"},
+		{func(conf *config.Config) { conf.Resolve = []string{"api.example.com:443:localhost"} }, `invalid resolve value: api.example.com:443:localhost, "localhost" is not an ip address`},
+		{func(conf *config.Config) { conf.ConnectTo = []string{"api.example.com:443:canary"} }, "invalid connect-to value: api.example.com:443:canary, expected HOST1:PORT1:HOST2:PORT2"},
+		{func(conf *config.Config) { conf.DNSServer = "dns.example.com" }, "invalid dns-server value: dns.example.com:53, expected ip:port"},
+	}
+
+	for _, tc := range testCases {
+		conf := config.New()
+		tc.update(conf)
+		_, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+		assert.EqualError(t, err, tc.expected)
+	}
+}
+
+func TestDialerDNSServer(t *testing.T) {
+	conf := config.New()
+	conf.DNSServer = "10.0.0.2, [2001:db8::1]:5353"
+	context, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+	assert.NotNil(t, context.Dialer.dialer.Resolver)
+	assert.True(t, context.Dialer.dialer.Resolver.PreferGo)
+}
+
+func TestDialerSourceAddress(t *testing.T) {
+	listener, err := net.Listen("tcp4", "0.0.0.0:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	conf := config.New()
+	conf.SourceAddress = "127.0.0.1, 127.0.0.2"
+	context, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+	assert.True(t, context.Dialer.BindsSourceAddress())
+
+	var sources []string
+	for i := 0; i < 3; i++ {
+		conn, err := context.Dialer.DialContext(ctx.Background(), "tcp", "127.0.0.1:"+port)
+		if assert.NoError(t, err) {
+			source, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+			sources = append(sources, source)
+			conn.Close()
+		}
+	}
+	assert.Equal(t, []string{"127.0.0.1", "127.0.0.2", "127.0.0.1"}, sources)
+
+	conf.SourceAddress = "10.0.0.300"
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "invalid source-address value: 10.0.0.300 is not an ip address")
+}
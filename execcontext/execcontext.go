@@ -1,33 +1,62 @@
 package execcontext
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/tednaleid/ganda/config"
 	"github.com/tednaleid/ganda/logger"
 	"io"
 	"log"
+	"log/slog"
 	"math"
+	"net/http"
+	"net/url"
 	"os"
 	"time"
 )
 
 type Context struct {
+	AcceptEncoding         string
+	Authenticator          Authenticator // nil unless --oauth2-token-url or --auth-command is given
 	BaseDirectory          string
 	BaseRetryDelayDuration time.Duration
+	ConnectionPools        int
 	ConnectTimeoutDuration time.Duration
+	CookieJar              *CookieJar // nil unless --cookie-jar is given
+	Dialer                 *Dialer
+	DryRun                 string // "curl" or "json" to print the requests instead of sending them
+	FailureWindow          int
+	IdleConnTimeout        time.Duration
+	HTTPProtocols          *http.Protocols // nil to use the transport's default of HTTP/2 when negotiated, otherwise HTTP/1.1
 	In                     io.Reader
 	Insecure               bool
 	JsonEnvelope           bool
 	Logger                 *logger.LeveledLogger
+	MaxConnsPerHost        int
+	MaxFailureRate         float64
+	MaxFailures            int
+	MaxIdleConns           int
+	MaxRedirects           int
+	Netrc                  *Netrc // nil unless --netrc or --netrc-file is given
+	NoFollowRedirects      bool
 	Out                    io.Writer
+	Proxy                  func(*http.Request) (*url.URL, error)
+	RequestBodyEncoding    string
 	RequestHeaders         []config.RequestHeader
 	RequestMethod          string
 	RequestWorkers         int
 	ResponseBody           config.ResponseBodyType
 	ResponseWorkers        int
+	Signer                 Signer // nil unless --aws-sigv4 or --sign-hmac is given
 	Retries                int
+	SameHostRedirects      bool
+	SaveCompressed         bool
 	SubdirLength           int
+	Summary                bool
 	ThrottlePerSecond      int
+	TLSConfig              *tls.Config
+	UnixSocket             string
 	WriteFiles             bool
 }
 
@@ -35,23 +64,42 @@ func New(conf *config.Config, in io.Reader, stderr io.Writer, stdout io.Writer)
 	var err error
 
 	context := Context{
+		AcceptEncoding:         conf.AcceptEncoding,
 		BaseDirectory:          conf.BaseDirectory,
 		BaseRetryDelayDuration: time.Duration(conf.BaseRetryDelayMillis) * time.Millisecond,
+		ConnectionPools:        conf.ConnectionPools,
 		ConnectTimeoutDuration: time.Duration(conf.ConnectTimeoutMillis) * time.Millisecond,
+		DryRun:                 conf.DryRun,
+		FailureWindow:          conf.FailureWindow,
+		IdleConnTimeout:        conf.IdleConnTimeout,
 		In:                     in,
 		Insecure:               conf.Insecure,
 		JsonEnvelope:           conf.JsonEnvelope,
-		Logger:                 createLeveledLogger(conf, stderr),
+		MaxConnsPerHost:        conf.MaxConnsPerHost,
+		MaxFailureRate:         conf.MaxFailureRate,
+		MaxFailures:            conf.MaxFailures,
+		MaxIdleConns:           conf.MaxIdleConns,
+		MaxRedirects:           conf.MaxRedirects,
+		NoFollowRedirects:      conf.NoFollowRedirects,
 		Out:                    stdout,
+		RequestBodyEncoding:    conf.RequestBodyEncoding,
 		RequestMethod:          conf.RequestMethod,
 		RequestWorkers:         conf.RequestWorkers,
-		RequestHeaders:         conf.RequestHeaders,
 		ResponseBody:           conf.ResponseBody,
 		Retries:                conf.Retries,
+		SameHostRedirects:      conf.SameHostRedirects,
+		SaveCompressed:         conf.SaveCompressed,
 		SubdirLength:           conf.SubdirLength,
+		Summary:                conf.Summary,
+		UnixSocket:             conf.UnixSocket,
 		ThrottlePerSecond:      math.MaxInt32,
 	}
 
+	context.Logger, err = createLeveledLogger(conf, stderr)
+	if err != nil {
+		return nil, err
+	}
+
 	if conf.ThrottlePerSecond > 0 {
 		context.ThrottlePerSecond = conf.ThrottlePerSecond
 	}
@@ -63,6 +111,72 @@ func New(conf *config.Config, in io.Reader, stderr io.Writer, stdout io.Writer)
 	// updating to a single response worker for now, need to fix a bug where they aren't sharing stdout properly
 	context.ResponseWorkers = 1
 
+	context.TLSConfig, err = newTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	context.Proxy, err = newProxyFunc(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	context.HTTPProtocols, err = httpProtocols(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	context.Dialer, err = newDialer(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.CookieJarFile != "" {
+		context.CookieJar, err = NewCookieJar(conf.CookieJarFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	context.RequestHeaders, err = requestHeaders(conf, context.Logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// needs the proxy, dialer and TLS config to request tokens
+	oauth2Authenticator, err := newOAuth2Authenticator(conf, &context)
+	if err != nil {
+		return nil, err
+	}
+	if oauth2Authenticator != nil {
+		context.Authenticator = oauth2Authenticator
+	}
+
+	if conf.AuthCommand != "" {
+		context.Authenticator = newCommandAuthenticator(conf.AuthCommand, conf.AuthCommandTTL)
+	}
+
+	if conf.AWSSigV4 != "" && conf.SignHMACKeyFile != "" {
+		return nil, errors.New("--aws-sigv4 and --sign-hmac can't be used together")
+	} else if conf.AWSSigV4 != "" {
+		context.Signer, err = newSigV4Signer(conf.AWSSigV4)
+		if err != nil {
+			return nil, err
+		}
+	} else if conf.SignHMACKeyFile != "" {
+		context.Signer, err = newHMACSigner(conf)
+		if err != nil {
+			return nil, err
+		}
+		// anyone with the signature could replay the request
+		context.Logger.AddSecretHeaders(conf.SignHMACHeader)
+	}
+
+	context.Netrc, err = newNetrc(conf)
+	if err != nil {
+		return nil, err
+	}
+
 	if len(conf.RequestFilename) > 0 {
 		// replace stdin with the file
 		context.In, err = requestFileReader(conf.RequestFilename)
@@ -77,19 +191,87 @@ func New(conf *config.Config, in io.Reader, stderr io.Writer, stdout io.Writer)
 	return &context, err
 }
 
-func createLeveledLogger(conf *config.Config, stderr io.Writer) *logger.LeveledLogger {
+// requestHeaders resolves the header values read from the environment or files and adds the
+// Authorization header for --user or --bearer-token-file unless one was given with --header
+func requestHeaders(conf *config.Config, logger *logger.LeveledLogger) ([]config.RequestHeader, error) {
+	headers, secretHeaders, err := resolveHeaderValues(conf.RequestHeaders)
+	if err != nil {
+		return nil, err
+	}
+	logger.AddSecretHeaders(secretHeaders...)
+
+	authorization, ok, err := authorizationHeader(conf)
+	if err != nil {
+		return nil, err
+	}
+	if ok && !hasHeader(headers, authorization.Key) {
+		headers = append(headers, authorization)
+	}
+
+	return headers, nil
+}
+
+// the --http1.1, --http2 and --http2-prior-knowledge flags each restrict the protocols that can be used
+func httpProtocols(conf *config.Config) (*http.Protocols, error) {
+	protocols := new(http.Protocols)
+	flags := 0
+
+	if conf.HTTP1 {
+		protocols.SetHTTP1(true)
+		flags++
+	}
+
+	// only HTTP/2 over TLS, the request fails if the server doesn't negotiate it
+	if conf.HTTP2 {
+		protocols.SetHTTP2(true)
+		flags++
+	}
+
+	// cleartext http urls use HTTP/2 without an upgrade, https urls still negotiate it
+	if conf.HTTP2PriorKnowledge {
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		flags++
+	}
+
+	if flags == 0 {
+		return nil, nil
+	} else if flags > 1 {
+		return nil, errors.New("only one of --http1.1, --http2 and --http2-prior-knowledge can be used")
+	}
+
+	return protocols, nil
+}
+
+func createLeveledLogger(conf *config.Config, stderr io.Writer) (*logger.LeveledLogger, error) {
 
 	if conf.Silent {
-		return logger.NewSilentLogger()
+		return logger.NewSilentLogger(), nil
 	}
 
-	stdErrLogger := log.New(stderr, "", 0)
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(conf.LogLevel)); err != nil {
+		return nil, fmt.Errorf("invalid log-level value: %s", conf.LogLevel)
+	}
 
-	if conf.Color {
-		return logger.NewLeveledLogger(stdErrLogger)
+	var leveledLogger *logger.LeveledLogger
+	switch conf.LogFormat {
+	case "json":
+		leveledLogger = logger.NewJsonLeveledLogger(stderr)
+	case "plain", "":
+		stdErrLogger := log.New(stderr, "", 0)
+		leveledLogger = logger.NewPlainLeveledLogger(stdErrLogger)
+		if conf.Color {
+			leveledLogger = logger.NewLeveledLogger(stdErrLogger)
+		}
+	default:
+		return nil, fmt.Errorf("invalid log-format value: %s", conf.LogFormat)
 	}
 
-	return logger.NewPlainLeveledLogger(stdErrLogger)
+	leveledLogger.SetLevel(level)
+	leveledLogger.SetVerbosity(conf.Verbose)
+
+	return leveledLogger, nil
 }
 
 func requestFileReader(requestFilename string) (io.Reader, error) {
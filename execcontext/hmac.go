@@ -0,0 +1,131 @@
+package execcontext
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tednaleid/ganda/config"
+)
+
+var hmacPlaceholderRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+
+// the values each placeholder in --sign-hmac-template is replaced with
+var hmacPlaceholders = map[string]func(request *http.Request, timestamp string, bodyHash string) string{
+	"{method}":      func(request *http.Request, _ string, _ string) string { return request.Method },
+	"{host}":        func(request *http.Request, _ string, _ string) string { return requestHost(request) },
+	"{path}":        func(request *http.Request, _ string, _ string) string { return request.URL.EscapedPath() },
+	"{query}":       func(request *http.Request, _ string, _ string) string { return request.URL.RawQuery },
+	"{timestamp}":   func(_ *http.Request, timestamp string, _ string) string { return timestamp },
+	"{body_sha256}": func(_ *http.Request, _ string, bodyHash string) string { return bodyHash },
+}
+
+// HMACSigner adds an HMAC of the canonical string built from --sign-hmac-template to each
+// request, along with the timestamp it covers
+type HMACSigner struct {
+	key             []byte
+	newHash         func() hash.Hash
+	template        string
+	encoding        string
+	header          string
+	timestampHeader string
+	hashBody        bool
+	now             func() time.Time
+}
+
+func newHMACSigner(conf *config.Config) (*HMACSigner, error) {
+	key, err := readSecretFile(conf.SignHMACKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read --sign-hmac key file: %w", err)
+	}
+	if key == "" {
+		return nil, fmt.Errorf("--sign-hmac key file %s is empty", conf.SignHMACKeyFile)
+	}
+
+	signer := &HMACSigner{
+		key:             []byte(key),
+		template:        strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(conf.SignHMACTemplate),
+		encoding:        conf.SignHMACEncoding,
+		header:          conf.SignHMACHeader,
+		timestampHeader: conf.SignHMACTimestampHeader,
+		now:             time.Now,
+	}
+
+	switch conf.SignHMACAlgorithm {
+	case "sha256":
+		signer.newHash = sha256.New
+	case "sha512":
+		signer.newHash = sha512.New
+	default:
+		return nil, fmt.Errorf("invalid sign-hmac-algorithm value: %s", conf.SignHMACAlgorithm)
+	}
+
+	if signer.encoding != "hex" && signer.encoding != "base64" {
+		return nil, fmt.Errorf("invalid sign-hmac-encoding value: %s", signer.encoding)
+	}
+
+	if signer.header == "" {
+		return nil, errors.New("--sign-hmac-header can't be empty")
+	}
+
+	for _, placeholder := range hmacPlaceholderRegexp.FindAllString(signer.template, -1) {
+		if _, ok := hmacPlaceholders[placeholder]; !ok {
+			return nil, fmt.Errorf("invalid sign-hmac-template value: unknown placeholder %s", placeholder)
+		}
+	}
+
+	// only read the body when the template needs its hash
+	signer.hashBody = strings.Contains(signer.template, "{body_sha256}")
+
+	return signer, nil
+}
+
+// Sign sets the signature header, and the timestamp header unless it's disabled with an empty name
+func (s *HMACSigner) Sign(request *http.Request) error {
+	var bodyHash string
+	if s.hashBody {
+		var err error
+		bodyHash, err = hashPayload(request)
+		if err != nil {
+			return fmt.Errorf("unable to sign request body: %w", err)
+		}
+	}
+
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+
+	canonical := hmacPlaceholderRegexp.ReplaceAllStringFunc(s.template, func(placeholder string) string {
+		return hmacPlaceholders[placeholder](request, timestamp, bodyHash)
+	})
+
+	mac := hmac.New(s.newHash, s.key)
+	mac.Write([]byte(canonical))
+
+	signature := hex.EncodeToString(mac.Sum(nil))
+	if s.encoding == "base64" {
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	request.Header.Set(s.header, signature)
+	if s.timestampHeader != "" {
+		request.Header.Set(s.timestampHeader, timestamp)
+	}
+
+	return nil
+}
+
+func requestHost(request *http.Request) string {
+	if request.Host != "" {
+		return request.Host
+	}
+	return request.URL.Host
+}
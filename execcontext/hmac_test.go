@@ -0,0 +1,92 @@
+package execcontext
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tednaleid/ganda/config"
+)
+
+func hmacConf(t *testing.T) *config.Config {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(keyFile, []byte("s3cret\n"), 0o600)
+
+	conf := config.New()
+	conf.SignHMACKeyFile = keyFile
+	return conf
+}
+
+func newTestHMACSigner(t *testing.T, conf *config.Config) *HMACSigner {
+	context, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+
+	signer := context.Signer.(*HMACSigner)
+	signer.now = func() time.Time { return time.Unix(1700000000, 0) }
+	return signer
+}
+
+func TestHMACSignerDefaultTemplate(t *testing.T) {
+	signer := newTestHMACSigner(t, hmacConf(t))
+
+	request, _ := http.NewRequest("POST", "https://api.example.com/items/1?verbose=true", strings.NewReader(`{"id":1}`))
+	assert.NoError(t, signer.Sign(request))
+
+	bodyHash := sha256.Sum256([]byte(`{"id":1}`))
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte("POST\n/items/1\n1700000000\n" + hex.EncodeToString(bodyHash[:])))
+
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), request.Header.Get("X-Signature"))
+	assert.Equal(t, "1700000000", request.Header.Get("X-Timestamp"))
+}
+
+func TestHMACSignerCustomTemplate(t *testing.T) {
+	conf := hmacConf(t)
+	conf.SignHMACAlgorithm = "sha512"
+	conf.SignHMACEncoding = "base64"
+	conf.SignHMACTemplate = `{host}|{path}?{query}|{timestamp}`
+	conf.SignHMACHeader = "X-Api-Signature"
+	conf.SignHMACTimestampHeader = ""
+	signer := newTestHMACSigner(t, conf)
+
+	request, _ := http.NewRequest("GET", "https://api.example.com/items?page=2", nil)
+	assert.NoError(t, signer.Sign(request))
+
+	mac := hmac.New(sha512.New, []byte("s3cret"))
+	mac.Write([]byte("api.example.com|/items?page=2|1700000000"))
+
+	assert.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), request.Header.Get("X-Api-Signature"))
+	assert.Empty(t, request.Header.Get("X-Timestamp"))
+}
+
+func TestHMACSignerValidation(t *testing.T) {
+	conf := hmacConf(t)
+	conf.SignHMACTemplate = `{method}\n{url}`
+	_, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "invalid sign-hmac-template value: unknown placeholder {url}")
+
+	conf = hmacConf(t)
+	conf.SignHMACAlgorithm = "md5"
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "invalid sign-hmac-algorithm value: md5")
+
+	conf = hmacConf(t)
+	conf.AWSSigV4 = "s3:us-east-1"
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "--aws-sigv4 and --sign-hmac can't be used together")
+
+	conf = config.New()
+	conf.SignHMACKeyFile = filepath.Join(t.TempDir(), "missing")
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.ErrorContains(t, err, "unable to read --sign-hmac key file")
+}
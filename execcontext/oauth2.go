@@ -0,0 +1,132 @@
+package execcontext
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tednaleid/ganda/config"
+)
+
+// tokens are refreshed this long before they expire, or after 90% of their lifetime if that's sooner
+const oauth2ExpiryWindow = time.Minute
+
+// oauth2Client gets tokens from the token url with the OAuth2 client credentials grant
+type oauth2Client struct {
+	client       *http.Client
+	tokenUrl     string
+	clientId     string
+	clientSecret string
+	scope        string
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// the token url is requested over the same proxy, dialer and TLS settings as the requests
+func newOAuth2Authenticator(conf *config.Config, context *Context) (*cachingAuthenticator, error) {
+	if conf.OAuth2TokenUrl == "" && conf.OAuth2ClientId == "" && conf.OAuth2ClientSecretFile == "" {
+		return nil, nil
+	}
+
+	if conf.OAuth2TokenUrl == "" || conf.OAuth2ClientId == "" || conf.OAuth2ClientSecretFile == "" {
+		return nil, errors.New("--oauth2-token-url, --oauth2-client-id and --oauth2-client-secret-file must be used together")
+	}
+
+	if tokenUrl, err := url.Parse(conf.OAuth2TokenUrl); err != nil || (tokenUrl.Scheme != "http" && tokenUrl.Scheme != "https") {
+		return nil, fmt.Errorf("invalid oauth2-token-url value: %s", conf.OAuth2TokenUrl)
+	}
+
+	clientSecret, err := readSecretFile(conf.OAuth2ClientSecretFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read --oauth2-client-secret-file: %w", err)
+	}
+
+	client := &oauth2Client{
+		client: &http.Client{
+			Timeout: context.ConnectTimeoutDuration,
+			Transport: &http.Transport{
+				Proxy:           context.Proxy,
+				DialContext:     context.Dialer.DialContext,
+				TLSClientConfig: context.TLSConfig.Clone(),
+			},
+		},
+		tokenUrl:     conf.OAuth2TokenUrl,
+		clientId:     conf.OAuth2ClientId,
+		clientSecret: clientSecret,
+		scope:        conf.OAuth2Scope,
+	}
+
+	return &cachingAuthenticator{fetch: client.authorization}, nil
+}
+
+// authorization returns a new token and when it should be refreshed, shortly before it expires
+func (c *oauth2Client) authorization(requestContext ctx.Context) (string, time.Time, error) {
+	token, err := c.fetchToken(requestContext)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("unable to get oauth2 token: %w", err)
+	}
+
+	var refreshAt time.Time
+	if token.ExpiresIn > 0 {
+		lifetime := time.Duration(token.ExpiresIn) * time.Second
+		refreshAt = time.Now().Add(lifetime - min(oauth2ExpiryWindow, lifetime/10))
+	}
+
+	return "Bearer " + token.AccessToken, refreshAt, nil
+}
+
+// the client id and secret are sent with basic auth, which every token endpoint has to support
+func (c *oauth2Client) fetchToken(requestContext ctx.Context) (*oauth2TokenResponse, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if c.scope != "" {
+		form.Set("scope", c.scope)
+	}
+
+	request, err := http.NewRequestWithContext(requestContext, "POST", c.tokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(c.clientId), url.QueryEscape(c.clientSecret))
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token url returned %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var token oauth2TokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+
+	if token.AccessToken == "" {
+		return nil, errors.New("invalid token response: missing access_token")
+	}
+
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return nil, fmt.Errorf("unsupported token_type %q", token.TokenType)
+	}
+
+	return &token, nil
+}
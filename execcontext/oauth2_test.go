@@ -0,0 +1,119 @@
+package execcontext
+
+import (
+	ctx "context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tednaleid/ganda/config"
+)
+
+func newTokenServer(t *testing.T, expiresIn int) (*httptest.Server, *atomic.Int32) {
+	var tokens atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientId, clientSecret, _ := r.BasicAuth()
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "ganda", clientId)
+		assert.Equal(t, "s3cret", clientSecret)
+		assert.Equal(t, "client_credentials", r.FormValue("grant_type"))
+		assert.Equal(t, "read write", r.FormValue("scope"))
+
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": %d}`, tokens.Add(1), expiresIn)
+	}))
+	t.Cleanup(server.Close)
+	return server, &tokens
+}
+
+func oauth2Conf(t *testing.T, tokenUrl string) *config.Config {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secretFile, []byte("s3cret\n"), 0o600)
+
+	conf := config.New()
+	conf.OAuth2TokenUrl = tokenUrl
+	conf.OAuth2ClientId = "ganda"
+	conf.OAuth2ClientSecretFile = secretFile
+	conf.OAuth2Scope = "read write"
+	return conf
+}
+
+func TestOAuth2TokenIsCachedUntilInvalidated(t *testing.T) {
+	server, tokens := newTokenServer(t, 3600)
+
+	context, err := New(oauth2Conf(t, server.URL), strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+
+	authorization, err := context.Authenticator.Authorization(ctx.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer token-1", authorization)
+
+	authorization, _ = context.Authenticator.Authorization(ctx.Background())
+	assert.Equal(t, "Bearer token-1", authorization)
+
+	// a stale token doesn't replace one another worker already refreshed
+	context.Authenticator.Invalidate("Bearer token-0")
+	authorization, _ = context.Authenticator.Authorization(ctx.Background())
+	assert.Equal(t, "Bearer token-1", authorization)
+
+	context.Authenticator.Invalidate("Bearer token-1")
+	authorization, _ = context.Authenticator.Authorization(ctx.Background())
+	assert.Equal(t, "Bearer token-2", authorization)
+	assert.Equal(t, int32(2), tokens.Load())
+}
+
+func TestOAuth2TokenIsRefreshedBeforeExpiry(t *testing.T) {
+	server, _ := newTokenServer(t, 3600)
+
+	context, err := New(oauth2Conf(t, server.URL), strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+
+	authenticator := context.Authenticator.(*cachingAuthenticator)
+	authenticator.Authorization(ctx.Background())
+	assert.WithinDuration(t, time.Now().Add(59*time.Minute), authenticator.refreshAt, 5*time.Second)
+
+	authenticator.refreshAt = time.Now().Add(-time.Second)
+	authorization, _ := authenticator.Authorization(ctx.Background())
+	assert.Equal(t, "Bearer token-2", authorization)
+}
+
+func TestOAuth2TokenErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": "invalid_client"}`)
+	}))
+	defer server.Close()
+
+	context, err := New(oauth2Conf(t, server.URL), strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+
+	_, err = context.Authenticator.Authorization(ctx.Background())
+	assert.EqualError(t, err, `unable to get oauth2 token: token url returned 401: {"error": "invalid_client"}`)
+}
+
+func TestOAuth2FlagValidation(t *testing.T) {
+	conf := config.New()
+	conf.OAuth2TokenUrl = "https://auth.example.com/token"
+	_, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "--oauth2-token-url, --oauth2-client-id and --oauth2-client-secret-file must be used together")
+
+	conf = oauth2Conf(t, "ftp://auth.example.com/token")
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "invalid oauth2-token-url value: ftp://auth.example.com/token")
+
+	conf = oauth2Conf(t, "https://auth.example.com/token")
+	conf.User = "alice:secret"
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "only one of --user, --bearer-token-file, --oauth2-token-url, --auth-command and --aws-sigv4 can be used")
+
+	context, err := New(config.New(), strings.NewReader(""), io.Discard, io.Discard)
+	assert.NoError(t, err)
+	assert.Nil(t, context.Authenticator)
+}
@@ -0,0 +1,59 @@
+package execcontext
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tednaleid/ganda/config"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// newProxyFunc returns the Proxy function for the transport, --proxy is used for both http and https
+// urls, otherwise the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are honored.
+// --no-proxy replaces NO_PROXY, requests to localhost are never proxied
+func newProxyFunc(conf *config.Config) (func(*http.Request) (*url.URL, error), error) {
+	proxyConfig := httpproxy.FromEnvironment()
+
+	if conf.Proxy != "" {
+		if err := validateProxy(conf.Proxy); err != nil {
+			return nil, err
+		}
+		proxyConfig.HTTPProxy = conf.Proxy
+		proxyConfig.HTTPSProxy = conf.Proxy
+	}
+
+	if conf.NoProxy != "" {
+		proxyConfig.NoProxy = conf.NoProxy
+	}
+
+	proxyFunc := proxyConfig.ProxyFunc()
+	return func(request *http.Request) (*url.URL, error) {
+		return proxyFunc(request.URL)
+	}, nil
+}
+
+// a proxy without a scheme is treated as http, credentials can be given in the url for either scheme
+func validateProxy(proxy string) error {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+
+	proxyUrl, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("invalid proxy value: %w", err)
+	}
+
+	switch proxyUrl.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("invalid proxy value: %s, the scheme must be http, https, socks5 or socks5h", proxyUrl.Redacted())
+	}
+
+	if proxyUrl.Host == "" {
+		return fmt.Errorf("invalid proxy value: %s, missing the host", proxyUrl.Redacted())
+	}
+
+	return nil
+}
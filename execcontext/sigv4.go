@@ -0,0 +1,287 @@
+package execcontext
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+)
+
+// headers that can be changed or removed on the way to AWS, they aren't signed
+var sigV4UnsignedHeaders = map[string]bool{
+	"authorization":   true,
+	"connection":      true,
+	"expect":          true,
+	"user-agent":      true,
+	"x-amzn-trace-id": true,
+}
+
+// SigV4Signer signs requests with AWS Signature Version 4 for --aws-sigv4
+type SigV4Signer struct {
+	service     string
+	region      string
+	credentials awsCredentials
+	now         func() time.Time
+}
+
+type awsCredentials struct {
+	accessKeyId     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+func newSigV4Signer(serviceRegion string) (*SigV4Signer, error) {
+	service, region, found := strings.Cut(serviceRegion, ":")
+	if !found || service == "" || region == "" {
+		return nil, fmt.Errorf("invalid aws-sigv4 value: %s, expected service:region", serviceRegion)
+	}
+
+	credentials, err := loadAWSCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigV4Signer{service: service, region: region, credentials: credentials, now: time.Now}, nil
+}
+
+// Sign sets the X-Amz-Date, X-Amz-Content-Sha256, X-Amz-Security-Token and Authorization headers,
+// the body is read through GetBody to hash it
+func (s *SigV4Signer) Sign(request *http.Request) error {
+	payloadHash, err := hashPayload(request)
+	if err != nil {
+		return fmt.Errorf("unable to sign request body: %w", err)
+	}
+
+	now := s.now().UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	scope := strings.Join([]string{now.Format("20060102"), s.region, s.service, "aws4_request"}, "/")
+
+	request.Header.Del("Authorization")
+	request.Header.Set("X-Amz-Date", amzDate)
+	if s.service == "s3" {
+		request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	if s.credentials.sessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", s.credentials.sessionToken)
+	}
+
+	signedHeaders, canonicalHeaders := canonicalHeaders(request)
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		s.canonicalPath(request),
+		canonicalQuery(request),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, hashHex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.credentials.secretAccessKey), now.Format("20060102"))
+	for _, part := range []string{s.region, s.service, "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.credentials.accessKeyId, scope, signedHeaders, signature))
+
+	return nil
+}
+
+// the path is used as it's sent, every service but S3 escapes it a second time
+func (s *SigV4Signer) canonicalPath(request *http.Request) string {
+	path := request.URL.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	if s.service == "s3" {
+		return path
+	}
+	return awsEscape(path, false)
+}
+
+// sorted by name and then value, with everything but unreserved characters escaped
+func canonicalQuery(request *http.Request) string {
+	var pairs []string
+	for name, values := range request.URL.Query() {
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(name, true)+"="+awsEscape(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// returns the signed header names and the canonical header lines, the Host header is always signed
+func canonicalHeaders(request *http.Request) (string, string) {
+	headers := map[string][]string{"host": {requestHost(request)}}
+
+	for name, values := range request.Header {
+		name = strings.ToLower(name)
+		if sigV4UnsignedHeaders[name] || name == "host" {
+			continue
+		}
+		headers[name] = append(headers[name], values...)
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines strings.Builder
+	for _, name := range names {
+		values := make([]string, len(headers[name]))
+		for i, value := range headers[name] {
+			values[i] = strings.Join(strings.Fields(value), " ")
+		}
+		lines.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	return strings.Join(names, ";"), lines.String()
+}
+
+// the body is read through GetBody so it's still there to send, a body without one would be
+// signed as empty and rejected
+func hashPayload(request *http.Request) (string, error) {
+	if request.GetBody == nil {
+		if request.Body != nil && request.Body != http.NoBody {
+			return "", errors.New("the request body can't be read again to sign it")
+		}
+		return hashHex(nil), nil
+	}
+
+	body, err := request.GetBody()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// awsEscape percent-encodes everything but the unreserved characters, slashes are kept
+// unless escapeSlash is set
+func awsEscape(value string, escapeSlash bool) string {
+	var escaped strings.Builder
+	for _, b := range []byte(value) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || (b == '/' && !escapeSlash) {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+func hashHex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// loadAWSCredentials uses the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables, or the AWS_PROFILE (default) profile of the shared credentials file
+func loadAWSCredentials() (awsCredentials, error) {
+	credentials := awsCredentials{
+		accessKeyId:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if credentials.accessKeyId != "" && credentials.secretAccessKey != "" {
+		return credentials, nil
+	}
+
+	filename := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if filename == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return credentials, fmt.Errorf("unable to find the AWS credentials file: %w", err)
+		}
+		filename = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return credentials, errors.New("no AWS credentials found, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or add them to " + filename)
+	} else if err != nil {
+		return credentials, fmt.Errorf("unable to read the AWS credentials file: %w", err)
+	}
+	defer file.Close()
+
+	credentials, err = parseAWSCredentials(bufio.NewScanner(file), profile)
+	if err != nil {
+		return credentials, fmt.Errorf("unable to read the AWS credentials file: %w", err)
+	}
+	if credentials.accessKeyId == "" || credentials.secretAccessKey == "" {
+		return credentials, fmt.Errorf("no AWS credentials found for the %s profile in %s", profile, filename)
+	}
+
+	return credentials, nil
+}
+
+// the credentials file is ini formatted with a [section] per profile
+func parseAWSCredentials(scanner *bufio.Scanner, profile string) (awsCredentials, error) {
+	var credentials awsCredentials
+	section := ""
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		if section != profile {
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, "=")
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			credentials.accessKeyId = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			credentials.secretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			credentials.sessionToken = strings.TrimSpace(value)
+		}
+	}
+
+	return credentials, scanner.Err()
+}
@@ -0,0 +1,164 @@
+package execcontext
+
+import (
+	ctx "context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tednaleid/ganda/config"
+	"github.com/tednaleid/ganda/parser"
+)
+
+// the example credentials and time from the AWS documentation
+func exampleSigner(service string, region string) *SigV4Signer {
+	return &SigV4Signer{
+		service: service,
+		region:  region,
+		credentials: awsCredentials{
+			accessKeyId:     "AKIDEXAMPLE",
+			secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		},
+		now: func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) },
+	}
+}
+
+func TestSigV4SignsGetVanilla(t *testing.T) {
+	request, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+
+	assert.NoError(t, exampleSigner("service", "us-east-1").Sign(request))
+
+	assert.Equal(t, "20150830T123600Z", request.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		request.Header.Get("Authorization"))
+}
+
+func TestSigV4SignsQueryAndHeaders(t *testing.T) {
+	request, _ := http.NewRequest("GET", "https://iam.amazonaws.com/?Version=2010-05-08&Action=ListUsers", nil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	request.Header.Set("User-Agent", "ganda")
+
+	assert.NoError(t, exampleSigner("iam", "us-east-1").Sign(request))
+
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		request.Header.Get("Authorization"))
+}
+
+func TestSigV4CanonicalPath(t *testing.T) {
+	request, _ := http.NewRequest("GET", "https://example.amazonaws.com/my%20bucket/a+b(1).txt", nil)
+
+	assert.Equal(t, "/my%2520bucket/a%2Bb%281%29.txt", exampleSigner("execute-api", "us-east-1").canonicalPath(request))
+	assert.Equal(t, "/my%20bucket/a+b(1).txt", exampleSigner("s3", "us-east-1").canonicalPath(request))
+}
+
+// the stub rebuilds the signature from the request it received, like AWS does
+func TestSigV4SignatureMatchesReceivedRequest(t *testing.T) {
+	signer := exampleSigner("s3", "eu-west-1")
+	signer.credentials.sessionToken = "session"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "contents", string(body))
+		assert.Equal(t, hashHex(body), r.Header.Get("X-Amz-Content-Sha256"))
+		assert.Equal(t, "session", r.Header.Get("X-Amz-Security-Token"))
+
+		// sign the received request again to check it matches what was sent
+		received := r.Header.Get("Authorization")
+		r.Header.Del("Accept-Encoding") // added by the transport after signing
+		r.Header.Del("Content-Length")
+		r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(string(body))), nil }
+		assert.NoError(t, signer.Sign(r))
+		assert.Equal(t, r.Header.Get("Authorization"), received)
+	}))
+	defer server.Close()
+
+	request := parseRequest(t, `{"url": "`+server.URL+`/bucket/key%20name?partNumber=1&uploadId=abc", "method": "PUT", "headers": {"Content-Type": "text/plain"}, "body": "contents", "bodyType": "escaped"}`)
+	assert.NoError(t, signer.Sign(request))
+
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	response.Body.Close()
+}
+
+func TestSigV4SignsParsedBodies(t *testing.T) {
+	signer := exampleSigner("execute-api", "us-east-1")
+
+	for _, line := range []string{
+		`{"url": "https://example.com/", "method": "POST", "body": {"id": 1}}`,
+		`{"url": "https://example.com/", "method": "POST", "body": "eyJpZCI6IDF9", "bodyType": "base64"}`,
+		`{"url": "https://example.com/", "method": "POST", "body": "{\"id\": 1}", "bodyType": "escaped"}`,
+	} {
+		request := parseRequest(t, line)
+		assert.NoError(t, signer.Sign(request))
+
+		hash, err := hashPayload(request)
+		assert.NoError(t, err)
+		assert.Equal(t, hashHex([]byte(`{"id": 1}`)), hash, line)
+	}
+
+	unreplayable, _ := http.NewRequest("POST", "https://example.com/", io.NopCloser(strings.NewReader("body")))
+	assert.EqualError(t, signer.Sign(unreplayable), "unable to sign request body: the request body can't be read again to sign it")
+}
+
+// parseRequest builds the request for a JSON line the way ganda does when it reads its input
+func parseRequest(t *testing.T, line string) *http.Request {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	err := parser.SendRequests(ctx.Background(), requestsWithContext, strings.NewReader(line), "GET", nil, "")
+	assert.NoError(t, err)
+	close(requestsWithContext)
+	return (<-requestsWithContext).Request
+}
+
+func TestSigV4Credentials(t *testing.T) {
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	os.WriteFile(credentialsFile, []byte(`
+[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = defaultsecret
+
+[staging]
+aws_access_key_id=AKIDSTAGING
+aws_secret_access_key=stagingsecret
+aws_session_token=stagingtoken
+`), 0o600)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_PROFILE", "")
+
+	credentials, err := loadAWSCredentials()
+	assert.NoError(t, err)
+	assert.Equal(t, awsCredentials{accessKeyId: "AKIDDEFAULT", secretAccessKey: "defaultsecret"}, credentials)
+
+	t.Setenv("AWS_PROFILE", "staging")
+	credentials, err = loadAWSCredentials()
+	assert.NoError(t, err)
+	assert.Equal(t, awsCredentials{accessKeyId: "AKIDSTAGING", secretAccessKey: "stagingsecret", sessionToken: "stagingtoken"}, credentials)
+
+	t.Setenv("AWS_PROFILE", "missing")
+	_, err = loadAWSCredentials()
+	assert.EqualError(t, err, "no AWS credentials found for the missing profile in "+credentialsFile)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+	credentials, err = loadAWSCredentials()
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIDENV", credentials.accessKeyId)
+}
+
+func TestSigV4FlagValidation(t *testing.T) {
+	conf := config.New()
+	conf.AWSSigV4 = "s3"
+	_, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "invalid aws-sigv4 value: s3, expected service:region")
+}
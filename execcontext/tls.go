@@ -0,0 +1,149 @@
+package execcontext
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/tednaleid/ganda/config"
+	"github.com/youmark/pkcs8"
+)
+
+// KeyPassphraseEnv is the environment variable holding the passphrase for an encrypted --key
+const KeyPassphraseEnv = "GANDA_KEY_PASSPHRASE"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig loads the CA bundle and client certificate once, every request worker's transport gets a clone
+func newTLSConfig(conf *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: conf.Insecure,
+		ServerName:         conf.ServerName,
+	}
+
+	if conf.TLSMinVersion != "" {
+		version, ok := tlsVersions[conf.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid tls-min-version value: %s", conf.TLSMinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if conf.CACertFile != "" {
+		pool, err := loadCertPool(conf.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if conf.CertFile != "" {
+		certificate, err := loadClientCertificate(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	} else if conf.KeyFile != "" {
+		return nil, errors.New("--key requires --cert")
+	}
+
+	return tlsConfig, nil
+}
+
+// like curl, the CA bundle replaces the system roots rather than adding to them
+func loadCertPool(caCertFile string) (*x509.CertPool, error) {
+	caCertPEM, err := os.ReadFile(caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read --cacert: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCertPEM) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", caCertFile)
+	}
+
+	return pool, nil
+}
+
+// the key can be in its own file or in the same PEM file as the certificate when --key isn't given
+func loadClientCertificate(certFile string, keyFile string) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to read --cert: %w", err)
+	}
+
+	keyPEM := certPEM
+	if keyFile != "" {
+		keyPEM, err = os.ReadFile(keyFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("unable to read --key: %w", err)
+		}
+	} else {
+		keyFile = certFile
+	}
+
+	keyPEM, err = decryptPrivateKey(keyPEM, keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to load client certificate %s: %w", certFile, err)
+	}
+
+	return certificate, nil
+}
+
+// decryptPrivateKey returns the PEM with its private key decrypted using the passphrase from
+// the environment, both PKCS#8 "ENCRYPTED PRIVATE KEY" and legacy OpenSSL encrypted keys are supported
+func decryptPrivateKey(keyPEM []byte, keyFile string) ([]byte, error) {
+	rest := keyPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			// nothing encrypted, let tls.X509KeyPair find the key
+			return keyPEM, nil
+		}
+
+		//nolint:staticcheck // legacy encrypted keys are still produced by `openssl genrsa -aes256`
+		legacyEncrypted := x509.IsEncryptedPEMBlock(block)
+		if block.Type != "ENCRYPTED PRIVATE KEY" && !legacyEncrypted {
+			continue
+		}
+
+		passphrase := os.Getenv(KeyPassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("%s is encrypted, set its passphrase in the %s environment variable", keyFile, KeyPassphraseEnv)
+		}
+
+		if legacyEncrypted {
+			//nolint:staticcheck // see above
+			der, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+			if err != nil {
+				return nil, fmt.Errorf("unable to decrypt %s: %w", keyFile, err)
+			}
+			return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+		}
+
+		key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt %s: %w", keyFile, err)
+		}
+
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+}
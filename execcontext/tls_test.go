@@ -0,0 +1,165 @@
+package execcontext
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tednaleid/ganda/config"
+	"github.com/youmark/pkcs8"
+)
+
+func TestNewTLSDefaults(t *testing.T) {
+	conf := config.New()
+	conf.Insecure = true
+	ctx, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+
+	assert.NoError(t, err)
+	assert.True(t, ctx.TLSConfig.InsecureSkipVerify)
+	assert.Nil(t, ctx.TLSConfig.RootCAs)
+	assert.Empty(t, ctx.TLSConfig.Certificates)
+	assert.Equal(t, uint16(0), ctx.TLSConfig.MinVersion)
+}
+
+func TestNewTLSOptions(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+
+	conf := config.New()
+	conf.CACertFile = certFile
+	conf.CertFile = certFile
+	conf.KeyFile = keyFile
+	conf.ServerName = "api.example.com"
+	conf.TLSMinVersion = "1.3"
+	ctx, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, ctx.TLSConfig.RootCAs)
+	assert.Len(t, ctx.TLSConfig.Certificates, 1)
+	assert.Equal(t, "api.example.com", ctx.TLSConfig.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS13), ctx.TLSConfig.MinVersion)
+}
+
+func TestNewTLSCertificateContainingKey(t *testing.T) {
+	directory := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, directory)
+	combinedFile := filepath.Join(directory, "combined.pem")
+	os.WriteFile(combinedFile, append(readFile(t, certFile), readFile(t, keyFile)...), 0o600)
+
+	conf := config.New()
+	conf.CertFile = combinedFile
+	ctx, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+
+	assert.NoError(t, err)
+	assert.Len(t, ctx.TLSConfig.Certificates, 1)
+}
+
+func TestNewTLSEncryptedKeys(t *testing.T) {
+	directory := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, directory)
+	key, err := x509.ParsePKCS8PrivateKey(pemBytes(t, readFile(t, keyFile)))
+	assert.NoError(t, err)
+
+	pkcs8Der, err := pkcs8.MarshalPrivateKey(key, []byte("secret"), nil)
+	assert.NoError(t, err)
+	pkcs8File := filepath.Join(directory, "pkcs8.key")
+	os.WriteFile(pkcs8File, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: pkcs8Der}), 0o600)
+
+	ecDer, err := x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey))
+	assert.NoError(t, err)
+	//nolint:staticcheck // creating a legacy encrypted key to test reading one
+	legacyBlock, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", ecDer, []byte("secret"), x509.PEMCipherAES256)
+	assert.NoError(t, err)
+	legacyFile := filepath.Join(directory, "legacy.key")
+	os.WriteFile(legacyFile, pem.EncodeToMemory(legacyBlock), 0o600)
+
+	for _, encryptedKeyFile := range []string{pkcs8File, legacyFile} {
+		conf := config.New()
+		conf.CertFile = certFile
+		conf.KeyFile = encryptedKeyFile
+
+		t.Setenv(KeyPassphraseEnv, "")
+		_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+		assert.EqualError(t, err, encryptedKeyFile+" is encrypted, set its passphrase in the GANDA_KEY_PASSPHRASE environment variable")
+
+		t.Setenv(KeyPassphraseEnv, "wrong")
+		_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+		assert.ErrorContains(t, err, "unable to decrypt "+encryptedKeyFile)
+
+		t.Setenv(KeyPassphraseEnv, "secret")
+		ctx, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+		assert.NoError(t, err)
+		assert.Len(t, ctx.TLSConfig.Certificates, 1)
+	}
+}
+
+func TestNewTLSErrors(t *testing.T) {
+	directory := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, directory)
+
+	conf := config.New()
+	conf.KeyFile = keyFile
+	_, err := New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "--key requires --cert")
+
+	conf = config.New()
+	conf.CACertFile = keyFile
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.EqualError(t, err, "no PEM certificates found in "+keyFile)
+
+	conf = config.New()
+	conf.CertFile = certFile
+	_, err = New(conf, strings.NewReader(""), io.Discard, io.Discard)
+	assert.ErrorContains(t, err, "unable to load client certificate "+certFile)
+}
+
+// writes a self-signed certificate for localhost and its unencrypted PKCS#8 key
+func writeTestCertificate(t *testing.T, directory string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile = filepath.Join(directory, "cert.pem")
+	keyFile = filepath.Join(directory, "cert.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600)
+	return certFile, keyFile
+}
+
+func readFile(t *testing.T, path string) []byte {
+	contents, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	return contents
+}
+
+func pemBytes(t *testing.T, contents []byte) []byte {
+	block, _ := pem.Decode(contents)
+	assert.NotNil(t, block)
+	return block.Bytes
+}
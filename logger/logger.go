@@ -1,11 +1,22 @@
 package logger
 
-import "log"
+import (
+	ctx "context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+)
 
 type LeveledLogger struct {
 	showColor bool
 	silent    bool
 	logger    *log.Logger
+	slogger   *slog.Logger // set for --log-format json, each event is logged as a JSON object instead
+	level     slog.Level   // events below this level aren't logged
+
+	verbosity     int             // 1 logs the headers of each request and response, 2 adds the start of their bodies
+	secretHeaders map[string]bool // canonical keys of headers to redact along with the sensitive ones
 }
 
 func NewSilentLogger() *LeveledLogger {
@@ -31,36 +42,65 @@ func NewLeveledLogger(logger *log.Logger) *LeveledLogger {
 	}
 }
 
-func (l *LeveledLogger) Info(format string, args ...interface{}) {
-	if !l.silent {
-		l.logger.Printf(format, args...)
+// NewJsonLeveledLogger writes one JSON object per line for each event, with the time, level,
+// message and an event field of 'message', 'response', 'error', 'request' or 'response_headers'
+func NewJsonLeveledLogger(out io.Writer) *LeveledLogger {
+	return &LeveledLogger{
+		silent:  false,
+		slogger: slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug})),
 	}
 }
 
+// SetLevel sets the minimum level of the events that are logged, --verbose output is logged
+// at debug level but is shown whenever it's asked for
+func (l *LeveledLogger) SetLevel(level slog.Level) {
+	l.level = level
+}
+
+func (l *LeveledLogger) enabled(level slog.Level) bool {
+	return !l.silent && level >= l.level
+}
+
+func (l *LeveledLogger) Info(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, "", "message", fmt.Sprintf(format, args...))
+}
+
 func (l *LeveledLogger) Warn(format string, args ...interface{}) {
-	if l.showColor {
-		l.logger.Printf("\033[31m"+format+"\033[0m", args...)
-	} else if !l.silent {
-		l.logger.Printf(format, args...)
-	}
+	l.log(slog.LevelWarn, "\033[31m", "message", fmt.Sprintf(format, args...))
 }
 
 func (l *LeveledLogger) Success(format string, args ...interface{}) {
-	if l.showColor {
-		l.logger.Printf("\033[32m"+format+"\033[0m", args...)
-	} else if !l.silent {
-		l.logger.Printf(format, args...)
-	}
+	l.log(slog.LevelInfo, "\033[32m", "message", fmt.Sprintf(format, args...))
 }
 
-func (l *LeveledLogger) LogResponse(statusCode int, message string) {
+// LogResponse logs the status of a response, the attrs like the url and attempt are only
+// included in JSON output
+func (l *LeveledLogger) LogResponse(statusCode int, message string, attrs ...slog.Attr) {
+	attrs = append([]slog.Attr{slog.Int("status", statusCode)}, attrs...)
 	if statusCode < 400 {
-		l.Success("Response: %d %s", statusCode, message)
+		l.log(slog.LevelInfo, "\033[32m", "response", fmt.Sprintf("Response: %d %s", statusCode, message), attrs...)
 	} else {
-		l.Warn("Response: %d %s", statusCode, message)
+		l.log(slog.LevelWarn, "\033[31m", "response", fmt.Sprintf("Response: %d %s", statusCode, message), attrs...)
 	}
 }
 
-func (l *LeveledLogger) LogError(err error, message string) {
-	l.Warn("%s Error: %s", message, err)
+func (l *LeveledLogger) LogError(err error, message string, attrs ...slog.Attr) {
+	attrs = append([]slog.Attr{slog.String("error", err.Error())}, attrs...)
+	l.log(slog.LevelError, "\033[31m", "error", fmt.Sprintf("%s Error: %s", message, err), attrs...)
+}
+
+// color is only used for plain output with --color
+func (l *LeveledLogger) log(level slog.Level, color string, event string, message string, attrs ...slog.Attr) {
+	if !l.enabled(level) {
+		return
+	}
+
+	if l.slogger != nil {
+		attrs = append([]slog.Attr{slog.String("event", event)}, attrs...)
+		l.slogger.LogAttrs(ctx.Background(), level, message, attrs...)
+	} else if l.showColor && color != "" {
+		l.logger.Print(color + message + "\033[0m")
+	} else {
+		l.logger.Print(message)
+	}
 }
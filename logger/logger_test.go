@@ -2,7 +2,12 @@ package logger
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"log"
+	"log/slog"
+	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -94,3 +99,117 @@ func TestLogError(t *testing.T) {
 	assert.Contains(t, buf.String(), "https://example.com")
 	assert.Contains(t, buf.String(), "Error:")
 }
+
+func TestRedactHeaders(t *testing.T) {
+	l := NewPlainLeveledLogger(log.New(new(bytes.Buffer), "", 0))
+	l.AddSecretHeaders("x-api-key")
+
+	redacted := l.RedactHeaders(http.Header{
+		"Authorization": {"Bearer abc"},
+		"Cookie":        {"session=abc"},
+		"X-Api-Key":     {"abc"},
+		"Accept":        {"application/json"},
+	})
+
+	assert.Equal(t, http.Header{
+		"Authorization": {"[REDACTED]"},
+		"Cookie":        {"[REDACTED]"},
+		"X-Api-Key":     {"[REDACTED]"},
+		"Accept":        {"application/json"},
+	}, redacted)
+}
+
+func TestLogRequestVerbosity(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := NewPlainLeveledLogger(log.New(buf, "", 0))
+	header := http.Header{"Authorization": {"Bearer abc"}, "Accept": {"*/*"}}
+
+	l.LogRequest("GET", "https://example.com/", header, []byte("ignored"))
+	assert.Equal(t, "", buf.String(), "nothing is logged without verbosity")
+
+	l.SetVerbosity(1)
+	l.LogRequest("GET", "https://example.com/", header, []byte("ignored"))
+	assert.Equal(t, "> GET https://example.com/\n> Accept: */*\n> Authorization: [REDACTED]\n", buf.String())
+
+	buf.Reset()
+	l.SetVerbosity(2)
+	l.LogResponseHeaders("HTTP/1.1", 200, "OK", "https://example.com/", http.Header{}, []byte("line 1\nline 2\n"))
+	assert.Equal(t, "< HTTP/1.1 200 OK https://example.com/\n<\n< line 1\n< line 2\n", buf.String())
+}
+
+func TestLogBodyIsTruncated(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := NewPlainLeveledLogger(log.New(buf, "", 0))
+	l.SetVerbosity(2)
+
+	l.LogRequest("POST", "https://example.com/", http.Header{}, bytes.Repeat([]byte("é"), VerboseBodyLimit))
+	assert.Contains(t, buf.String(), "> "+strings.Repeat("é", VerboseBodyLimit/2)+"\n> [truncated to 1024 bytes]\n")
+
+	buf.Reset()
+	l.LogRequest("POST", "https://example.com/", http.Header{}, []byte{0xff, 0xfe, 0x00, 0x01})
+	assert.Equal(t, "> POST https://example.com/\n>\n> [binary body]\n", buf.String())
+}
+
+func TestSilentLoggerIsNeverVerbose(t *testing.T) {
+	l := NewSilentLogger()
+	l.SetVerbosity(2)
+
+	assert.Equal(t, 0, l.Verbosity())
+	l.LogRequest("GET", "https://example.com/", http.Header{}, nil)
+}
+
+func decodeJsonLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var events []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(line), &event))
+		delete(event, "time")
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestJsonLoggerEvents(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := NewJsonLeveledLogger(buf)
+
+	l.LogResponse(200, "https://example.com/", slog.String("url", "https://example.com/"), slog.Int("attempt", 1))
+	l.LogResponse(503, "https://example.com/")
+	l.LogError(errors.New("connection refused"), "https://example.com/", slog.String("url", "https://example.com/"))
+	l.Info("Summary: %d requests", 3)
+
+	assert.Equal(t, []map[string]any{
+		{"level": "INFO", "msg": "Response: 200 https://example.com/", "event": "response", "status": float64(200), "url": "https://example.com/", "attempt": float64(1)},
+		{"level": "WARN", "msg": "Response: 503 https://example.com/", "event": "response", "status": float64(503)},
+		{"level": "ERROR", "msg": "https://example.com/ Error: connection refused", "event": "error", "error": "connection refused", "url": "https://example.com/"},
+		{"level": "INFO", "msg": "Summary: 3 requests", "event": "message"},
+	}, decodeJsonLines(t, buf))
+}
+
+func TestJsonLoggerVerboseEvents(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := NewJsonLeveledLogger(buf)
+	l.SetLevel(slog.LevelError)
+	l.SetVerbosity(1)
+
+	l.LogRequest("GET", "https://example.com/", http.Header{"Cookie": {"session=abc"}}, nil)
+	l.LogResponseHeaders("HTTP/1.1", 404, "Not Found", "https://example.com/", http.Header{"Set-Cookie": {"session=abc"}}, nil)
+
+	assert.Equal(t, []map[string]any{
+		{"level": "DEBUG", "msg": "GET https://example.com/", "event": "request", "method": "GET", "url": "https://example.com/", "headers": map[string]any{"Cookie": []any{"[REDACTED]"}}},
+		{"level": "DEBUG", "msg": "HTTP/1.1 404 Not Found https://example.com/", "event": "response_headers", "protocol": "HTTP/1.1", "status": float64(404), "reason": "Not Found", "url": "https://example.com/", "headers": map[string]any{"Set-Cookie": []any{"[REDACTED]"}}},
+	}, decodeJsonLines(t, buf))
+}
+
+func TestLogLevelFiltersEvents(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := NewPlainLeveledLogger(log.New(buf, "", 0))
+	l.SetLevel(slog.LevelWarn)
+
+	l.Info("hidden")
+	l.LogResponse(200, "https://example.com/")
+	l.LogResponse(404, "https://example.com/missing")
+	l.LogError(errors.New("timeout"), "https://example.com/slow")
+
+	assert.Equal(t, "Response: 404 https://example.com/missing\nhttps://example.com/slow Error: timeout\n", buf.String())
+}
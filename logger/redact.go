@@ -0,0 +1,47 @@
+package logger
+
+import "net/http"
+
+const Redacted = "[REDACTED]"
+
+// headers that carry credentials, their values are never logged
+var sensitiveHeaders = map[string]bool{
+	"Authorization":        true,
+	"Cookie":               true,
+	"Proxy-Authorization":  true,
+	"Set-Cookie":           true,
+	"X-Amz-Security-Token": true,
+}
+
+// AddSecretHeaders marks more headers to redact, like the ones whose values were read from
+// the environment or a file
+func (l *LeveledLogger) AddSecretHeaders(keys ...string) {
+	if l.secretHeaders == nil {
+		l.secretHeaders = make(map[string]bool, len(keys))
+	}
+	for _, key := range keys {
+		l.secretHeaders[http.CanonicalHeaderKey(key)] = true
+	}
+}
+
+// RedactHeader returns the value to log for the header
+func (l *LeveledLogger) RedactHeader(key string, value string) string {
+	key = http.CanonicalHeaderKey(key)
+	if sensitiveHeaders[key] || l.secretHeaders[key] {
+		return Redacted
+	}
+	return value
+}
+
+// RedactHeaders returns a copy of the headers that's safe to log
+func (l *LeveledLogger) RedactHeaders(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+	for key, values := range header {
+		redactedValues := make([]string, len(values))
+		for i, value := range values {
+			redactedValues[i] = l.RedactHeader(key, value)
+		}
+		redacted[key] = redactedValues
+	}
+	return redacted
+}
@@ -0,0 +1,143 @@
+package logger
+
+import (
+	ctx "context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// VerboseBodyLimit is the most of each body logged at verbosity 2, callers pass up to a byte more
+// so a truncated body can be marked as one
+const VerboseBodyLimit = 1024
+
+// SetVerbosity sets how much of each request and response is logged, 0 logs none of it
+func (l *LeveledLogger) SetVerbosity(verbosity int) {
+	l.verbosity = verbosity
+}
+
+// Verbosity is 0 unless --verbose is given, it's always 0 for a silent logger
+func (l *LeveledLogger) Verbosity() int {
+	if l.silent {
+		return 0
+	}
+	return l.verbosity
+}
+
+// LogRequest logs the request line and headers curl-style, prefixed with '>', and the start of
+// the body at verbosity 2.  Each request is logged in a single write so lines logged by other
+// workers don't interleave with it
+func (l *LeveledLogger) LogRequest(method string, url string, header http.Header, body []byte) {
+	if l.Verbosity() < 1 {
+		return
+	}
+
+	if l.slogger != nil {
+		l.slogger.LogAttrs(ctx.Background(), slog.LevelDebug, method+" "+url,
+			append([]slog.Attr{slog.String("event", "request"), slog.String("method", method), slog.String("url", url)}, l.detailAttrs(header, body)...)...)
+		return
+	}
+
+	var lines strings.Builder
+	fmt.Fprintf(&lines, "> %s %s\n", method, url)
+	l.writeHeaders(&lines, "> ", header)
+	l.writeBody(&lines, "> ", body)
+	l.logger.Print(strings.TrimSuffix(lines.String(), "\n"))
+}
+
+// LogResponseHeaders logs the status line and headers, prefixed with '<', and the start of
+// the body at verbosity 2
+func (l *LeveledLogger) LogResponseHeaders(proto string, code int, reason string, url string, header http.Header, body []byte) {
+	if l.Verbosity() < 1 {
+		return
+	}
+
+	status := strconv.Itoa(code)
+	if reason != "" {
+		status += " " + reason
+	}
+
+	if l.slogger != nil {
+		l.slogger.LogAttrs(ctx.Background(), slog.LevelDebug, proto+" "+status+" "+url,
+			append([]slog.Attr{slog.String("event", "response_headers"), slog.String("protocol", proto), slog.Int("status", code), slog.String("reason", reason), slog.String("url", url)}, l.detailAttrs(header, body)...)...)
+		return
+	}
+
+	var lines strings.Builder
+	fmt.Fprintf(&lines, "< %s %s %s\n", proto, status, url)
+	l.writeHeaders(&lines, "< ", header)
+	l.writeBody(&lines, "< ", body)
+	l.logger.Print(strings.TrimSuffix(lines.String(), "\n"))
+}
+
+// the redacted headers as a group and, at verbosity 2, the start of the body
+func (l *LeveledLogger) detailAttrs(header http.Header, body []byte) []slog.Attr {
+	var headers []any
+	for key, values := range l.RedactHeaders(header) {
+		headers = append(headers, slog.Any(key, values))
+	}
+	attrs := []slog.Attr{slog.Group("headers", headers...)}
+
+	if l.Verbosity() >= 2 && len(body) > 0 {
+		truncated := len(body) > VerboseBodyLimit
+		body = body[:min(len(body), VerboseBodyLimit)]
+		if isText(body) {
+			attrs = append(attrs, slog.String("body", string(body)))
+		} else {
+			attrs = append(attrs, slog.Bool("binary_body", true))
+		}
+		attrs = append(attrs, slog.Bool("body_truncated", truncated))
+	}
+
+	return attrs
+}
+
+// headers are sorted so the output is stable, the sensitive ones are redacted
+func (l *LeveledLogger) writeHeaders(lines *strings.Builder, prefix string, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range header[key] {
+			fmt.Fprintf(lines, "%s%s: %s\n", prefix, key, l.RedactHeader(key, value))
+		}
+	}
+}
+
+// the body is read up to a byte past the limit to tell when it's truncated
+func (l *LeveledLogger) writeBody(lines *strings.Builder, prefix string, body []byte) {
+	if l.Verbosity() < 2 || len(body) == 0 {
+		return
+	}
+
+	truncated := len(body) > VerboseBodyLimit
+	body = body[:min(len(body), VerboseBodyLimit)]
+
+	lines.WriteString(strings.TrimSpace(prefix) + "\n")
+	if isText(body) {
+		for _, line := range strings.Split(strings.TrimSuffix(string(body), "\n"), "\n") {
+			lines.WriteString(prefix + line + "\n")
+		}
+	} else {
+		fmt.Fprintf(lines, "%s[binary body]\n", prefix)
+	}
+
+	if truncated {
+		fmt.Fprintf(lines, "%s[truncated to %d bytes]\n", prefix, VerboseBodyLimit)
+	}
+}
+
+// a truncated body can end part way through a character
+func isText(body []byte) bool {
+	for i := 0; i < utf8.UTFMax-1 && !utf8.Valid(body) && len(body) > 0; i++ {
+		body = body[:len(body)-1]
+	}
+	return utf8.Valid(body)
+}
@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressBody replaces the request body with one compressed with the encoding and sets the
+// Content-Encoding header.  Bodies parsed from the JSON line are compressed once so retries
+// resend the same bytes, file and multipart bodies are compressed as they're streamed
+func compressBody(request *http.Request, encoding string) error {
+	if encoding == "" || encoding == "identity" {
+		return nil
+	}
+
+	if encoding != "gzip" && encoding != "zstd" {
+		return fmt.Errorf("unsupported body encoding: %s, valid values: \"gzip\", \"zstd\", \"identity\"", encoding)
+	}
+
+	if request.GetBody != nil && request.Body == nil {
+		compressStreamedBody(request, encoding)
+	} else if request.Body != nil {
+		if err := compressParsedBody(request, encoding); err != nil {
+			return err
+		}
+	}
+
+	if request.ContentLength != 0 {
+		request.Header.Set("Content-Encoding", encoding)
+	}
+
+	return nil
+}
+
+// the compressed length isn't known ahead of time, so it's sent with chunked encoding
+func compressStreamedBody(request *http.Request, encoding string) {
+	getUncompressedBody := request.GetBody
+
+	request.ContentLength = -1
+	request.GetBody = func() (io.ReadCloser, error) {
+		uncompressedBody, err := getUncompressedBody()
+		if err != nil {
+			return nil, err
+		}
+
+		reader, writer := io.Pipe()
+		go func() {
+			defer uncompressedBody.Close()
+			writer.CloseWithError(compress(writer, uncompressedBody, encoding))
+		}()
+		return reader, nil
+	}
+}
+
+func compressParsedBody(request *http.Request, encoding string) error {
+	uncompressed, err := io.ReadAll(request.Body)
+	request.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	// nothing to compress, send it without a body like before
+	if len(uncompressed) == 0 {
+		request.Body = http.NoBody
+		request.ContentLength = 0
+		return nil
+	}
+
+	compressed := new(bytes.Buffer)
+	if err := compress(compressed, bytes.NewReader(uncompressed), encoding); err != nil {
+		return err
+	}
+
+	body := compressed.Bytes()
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	request.ContentLength = int64(len(body))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return nil
+}
+
+func compress(out io.Writer, in io.Reader, encoding string) error {
+	compressor, err := newCompressor(out, encoding)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(compressor, in); err != nil {
+		compressor.Close()
+		return err
+	}
+
+	return compressor.Close()
+}
+
+func newCompressor(out io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(out), nil
+	case "zstd":
+		return zstd.NewWriter(out, zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, fmt.Errorf("unsupported body encoding: %s", encoding)
+	}
+}
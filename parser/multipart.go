@@ -0,0 +1,193 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// a multipart body is an object of field names to values, a value is a string, number or boolean
+// for a form field, an object for a file part, or an array of those to repeat the field:
+//
+//	{ "title": "report", "document": { "file": "path/to/report.pdf", "filename": "q3.pdf", "contentType": "application/pdf" } }
+type multipartFile struct {
+	File        string `json:"file"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+}
+
+type multipartPart struct {
+	name  string
+	value string
+	file  *multipartFile
+	size  int64
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// setMultipartBody checks the files exist while parsing, but they are only read by the request
+// worker through GetBody when the request is sent.  The boundary is generated once so every
+// attempt sends an identical body with the Content-Length calculated up front
+func setMultipartBody(request *http.Request, body json.RawMessage) error {
+	parts, err := parseMultipartParts(body)
+	if err != nil {
+		return err
+	}
+
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	// the length of everything but the file contents, which we know from their size
+	counter := &countingWriter{}
+	if err := writeMultipart(counter, boundary, parts, false); err != nil {
+		return err
+	}
+
+	contentLength := counter.count
+	for _, part := range parts {
+		contentLength += part.size
+	}
+
+	request.Body = nil
+	request.ContentLength = contentLength
+	request.GetBody = func() (io.ReadCloser, error) {
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(writeMultipart(writer, boundary, parts, true))
+		}()
+		return reader, nil
+	}
+	request.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+
+	return nil
+}
+
+func parseMultipartParts(body json.RawMessage) ([]multipartPart, error) {
+	var parts []multipartPart
+
+	err := decodeObjectInOrder(body, func(name string, rawValue json.RawMessage) error {
+		var rawValues []json.RawMessage
+		if err := json.Unmarshal(rawValue, &rawValues); err != nil {
+			rawValues = []json.RawMessage{rawValue}
+		}
+
+		for _, rawElement := range rawValues {
+			part, err := parseMultipartPart(name, rawElement)
+			if err != nil {
+				return err
+			}
+			parts = append(parts, part)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("multipart body must be an object of fields: %w", err)
+	}
+
+	return parts, nil
+}
+
+func parseMultipartPart(name string, rawValue json.RawMessage) (multipartPart, error) {
+	var file multipartFile
+	if err := json.Unmarshal(rawValue, &file); err != nil {
+		// not an object, so it's a form field
+		var value interface{}
+		if err := json.Unmarshal(rawValue, &value); err != nil {
+			return multipartPart{}, err
+		}
+
+		values, err := formatParameterValues("multipart field", name, value)
+		if err != nil {
+			return multipartPart{}, err
+		}
+
+		return multipartPart{name: name, value: values[0]}, nil
+	}
+
+	if file.File == "" {
+		return multipartPart{}, fmt.Errorf("multipart file part %s is missing the file property", name)
+	}
+
+	info, err := os.Stat(file.File)
+	if err != nil {
+		return multipartPart{}, err
+	}
+	if info.IsDir() {
+		return multipartPart{}, fmt.Errorf("%s is a directory", file.File)
+	}
+
+	if file.Filename == "" {
+		file.Filename = filepath.Base(file.File)
+	}
+
+	if file.ContentType == "" {
+		file.ContentType = mime.TypeByExtension(filepath.Ext(file.File))
+		if file.ContentType == "" {
+			file.ContentType = "application/octet-stream"
+		}
+	}
+
+	return multipartPart{name: name, file: &file, size: info.Size()}, nil
+}
+
+// writes the multipart body, file contents are skipped unless includeFiles is set
+func writeMultipart(out io.Writer, boundary string, parts []multipartPart, includeFiles bool) error {
+	writer := multipart.NewWriter(out)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	for _, part := range parts {
+		if part.file == nil {
+			if err := writer.WriteField(part.name, part.value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quoteEscaper.Replace(part.name), quoteEscaper.Replace(part.file.Filename)))
+		header.Set("Content-Type", part.file.ContentType)
+
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			return err
+		}
+
+		if includeFiles {
+			if err := copyFile(partWriter, part.file.File); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writer.Close()
+}
+
+func copyFile(out io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(out, file)
+	return err
+}
+
+type countingWriter struct {
+	count int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.count += int64(len(p))
+	return len(p), nil
+}
@@ -3,19 +3,30 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/tednaleid/ganda/config"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type InputType int
 
+// bodyFilePrefix marks the first context field of an url line as the file to send as the body
+const bodyFilePrefix = "@file:"
+
 const (
 	Unknown InputType = iota
 	Urls
@@ -23,15 +34,34 @@ const (
 )
 
 type RequestWithContext struct {
-	Request        *http.Request
-	RequestContext interface{}
+	Request          *http.Request
+	RequestContext   interface{}
+	Overrides        *RequestOverrides // nil unless the JSON line overrides a batch setting
+	BodyFile         string            // the file sent as the body, it's only read as the request is sent
+	BodyFileEncoding string            // the encoding BodyFile is compressed with as it's sent, empty when it's sent as is
+}
+
+// MaxJsonLineSize is the longest JSON line that can be read, including its newline
+const MaxJsonLineSize = 1024 * 1024
+
+// RequestOverrides are per-request replacements for the batch settings, unset fields use the batch setting
+type RequestOverrides struct {
+	Timeout         time.Duration
+	Retries         *int
+	OutputFile      string // relative to the --output-directory
+	FollowRedirects *bool
+	UnixSocket      string // dial this socket instead of the url's host
 }
 
+// SendRequests parses the input and sends a request for each line until the input is exhausted
+// or ctx is cancelled, in which case ctx.Err() is returned and the rest of the input is left unread
 func SendRequests(
+	ctx context.Context,
 	requestsWithContext chan<- RequestWithContext,
 	in io.Reader,
 	requestMethod string,
 	staticHeaders []config.RequestHeader,
+	bodyEncoding string,
 ) error {
 	reader := bufio.NewReader(in)
 	inputType, err := determineInputType(reader)
@@ -42,19 +72,21 @@ func SendRequests(
 	}
 
 	if inputType == JsonLines {
-		return SendJsonLinesRequests(requestsWithContext, reader, requestMethod, staticHeaders)
+		return SendJsonLinesRequests(ctx, requestsWithContext, reader, requestMethod, staticHeaders, bodyEncoding)
 	}
 
-	return SendUrlsRequests(requestsWithContext, reader, requestMethod, staticHeaders)
+	return SendUrlsRequests(ctx, requestsWithContext, reader, requestMethod, staticHeaders, bodyEncoding)
 }
 
 // Each line is an URL and optionally some TSV context that can be passed through
 // an emitted along with the response output
 func SendUrlsRequests(
+	ctx context.Context,
 	requestsWithContext chan<- RequestWithContext,
 	reader *bufio.Reader,
 	requestMethod string,
 	staticHeaders []config.RequestHeader,
+	bodyEncoding string,
 ) error {
 	tsvReader := csv.NewReader(reader)
 	tsvReader.Comma = '\t'
@@ -74,35 +106,67 @@ func SendUrlsRequests(
 			if err != nil {
 				return fmt.Errorf("invalid request for %s: %w", url, err)
 			}
+			requestWithContext := RequestWithContext{Request: request}
 			recordContext := record[1:]
 
+			// a first context field of @file:PATH sends that file as the body, other values
+			// starting with @ (like a @handle) are ordinary context
+			if len(recordContext) > 0 && strings.HasPrefix(recordContext[0], bodyFilePrefix) {
+				requestWithContext.BodyFile = strings.TrimPrefix(recordContext[0], bodyFilePrefix)
+				err = setBodyFile(request, requestWithContext.BodyFile)
+				if err != nil {
+					return fmt.Errorf("invalid body file for %s: %w", url, err)
+				}
+				recordContext = recordContext[1:]
+
+				err = compressBody(request, bodyEncoding)
+				if err != nil {
+					return fmt.Errorf("failed to compress body for %s: %w", url, err)
+				}
+				requestWithContext.BodyFileEncoding = fileEncoding(bodyEncoding)
+			}
+
 			if len(recordContext) == 0 {
 				recordContext = nil
 			}
+			requestWithContext.RequestContext = recordContext
 
-			requestsWithContext <- RequestWithContext{Request: request, RequestContext: recordContext}
+			err = sendRequest(ctx, requestsWithContext, requestWithContext)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
 type JsonLine struct {
-	URL      string            `json:"url"`
-	Method   string            `json:"method"`
-	Context  interface{}       `json:"context"`
-	Headers  map[string]string `json:"headers"`
-	Body     json.RawMessage   `json:"body"`
-	BodyType string            `json:"bodyType"`
+	URL             string                 `json:"url"`
+	Method          string                 `json:"method"`
+	Context         interface{}            `json:"context"`
+	Headers         JsonLineHeaders        `json:"headers"`
+	Body            json.RawMessage        `json:"body"`
+	BodyType        string                 `json:"bodyType"`
+	BodyFile        string                 `json:"bodyFile"`
+	BodyEncoding    string                 `json:"bodyEncoding"`
+	Query           map[string]interface{} `json:"query"`
+	TimeoutMillis   int                    `json:"timeoutMillis"`
+	Retries         *int                   `json:"retries"`
+	OutputFile      string                 `json:"outputFile"`
+	FollowRedirects *bool                  `json:"followRedirects"`
+	UnixSocket      string                 `json:"unixSocket"`
 }
 
 func SendJsonLinesRequests(
+	ctx context.Context,
 	requestsWithContext chan<- RequestWithContext,
 	reader *bufio.Reader,
 	requestMethod string,
 	staticHeaders []config.RequestHeader,
+	bodyEncoding string,
 ) error {
 	scanner := bufio.NewScanner(reader)
-	scanner.Buffer(make([]byte, bufio.MaxScanTokenSize), 1024*1024) // 1MB max line size
+	scanner.Buffer(make([]byte, bufio.MaxScanTokenSize), MaxJsonLineSize)
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -115,9 +179,16 @@ func SendJsonLinesRequests(
 			return fmt.Errorf("missing url property: %s", line)
 		}
 
-		body, err := parseBody(jsonLine.BodyType, jsonLine.Body)
-		if err != nil {
-			return fmt.Errorf("failed to parse body: %s", err)
+		var body io.Reader
+		if jsonLine.BodyFile != "" {
+			if len(jsonLine.Body) > 0 {
+				return fmt.Errorf("only one of body and bodyFile can be given: %s", line)
+			}
+		} else {
+			body, err = parseBody(jsonLine.BodyType, jsonLine.Body)
+			if err != nil {
+				return fmt.Errorf("failed to parse body: %s", err)
+			}
 		}
 
 		// allow overriding of the request method per JSON line, but otherwise use the default
@@ -132,7 +203,52 @@ func SendJsonLinesRequests(
 		if err != nil {
 			return fmt.Errorf("invalid request for %s: %w", jsonLine.URL, err)
 		}
-		requestsWithContext <- RequestWithContext{Request: request, RequestContext: jsonLine.Context}
+
+		if jsonLine.BodyFile != "" {
+			err = setBodyFile(request, jsonLine.BodyFile)
+			if err != nil {
+				return fmt.Errorf("invalid body file for %s: %w", jsonLine.URL, err)
+			}
+		} else if jsonLine.BodyType == "form" && request.Header.Get("Content-Type") == "" {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else if jsonLine.BodyType == "multipart" {
+			err = setMultipartBody(request, jsonLine.Body)
+			if err != nil {
+				return fmt.Errorf("failed to parse body: %s", err)
+			}
+		}
+
+		// allow overriding of the body encoding per JSON line, "identity" sends it uncompressed
+		encoding := bodyEncoding
+		if jsonLine.BodyEncoding != "" {
+			encoding = jsonLine.BodyEncoding
+		}
+
+		err = compressBody(request, encoding)
+		if err != nil {
+			return fmt.Errorf("failed to compress body for %s: %w", jsonLine.URL, err)
+		}
+
+		err = mergeQuery(request.URL, jsonLine.Query)
+		if err != nil {
+			return fmt.Errorf("invalid query for %s: %w", jsonLine.URL, err)
+		}
+
+		overrides, err := parseOverrides(jsonLine)
+		if err != nil {
+			return fmt.Errorf("invalid request for %s: %w", jsonLine.URL, err)
+		}
+
+		requestWithContext := RequestWithContext{Request: request, RequestContext: jsonLine.Context, Overrides: overrides}
+		if jsonLine.BodyFile != "" {
+			requestWithContext.BodyFile = jsonLine.BodyFile
+			requestWithContext.BodyFileEncoding = fileEncoding(encoding)
+		}
+
+		err = sendRequest(ctx, requestsWithContext, requestWithContext)
+		if err != nil {
+			return err
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -142,36 +258,278 @@ func SendJsonLinesRequests(
 	return nil
 }
 
-func mergeHeaders(staticHeaders []config.RequestHeader, jsonLineHeaders map[string]string) []config.RequestHeader {
+// blocks until a request worker is ready for the request or ctx is cancelled
+func sendRequest(ctx context.Context, requestsWithContext chan<- RequestWithContext, requestWithContext RequestWithContext) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case requestsWithContext <- requestWithContext:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// JsonLineHeaders keeps the headers of a JSON line in the order they were given, they can be an object
+// of names to a value or array of values, or an array of [name, value] pairs. A null value (or empty
+// array) removes any static header with that name
+type JsonLineHeaders []JsonLineHeader
+
+type JsonLineHeader struct {
+	Key    string
+	Values []string
+}
+
+func (headers *JsonLineHeaders) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+
+	switch {
+	case bytes.Equal(trimmed, []byte("null")):
+		*headers = nil
+		return nil
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		return headers.unmarshalPairs(trimmed)
+	case len(trimmed) > 0 && trimmed[0] == '{':
+		return headers.unmarshalObject(trimmed)
+	default:
+		return errors.New("headers must be an object or an array of [name, value] pairs")
+	}
+}
+
+func (headers *JsonLineHeaders) unmarshalObject(data []byte) error {
+	return decodeObjectInOrder(data, func(key string, rawValue json.RawMessage) error {
+		values, err := parseHeaderValues(key, rawValue)
+		if err != nil {
+			return err
+		}
+
+		*headers = append(*headers, JsonLineHeader{Key: key, Values: values})
+		return nil
+	})
+}
+
+func (headers *JsonLineHeaders) unmarshalPairs(data []byte) error {
+	var pairs [][]*string
+	if err := json.Unmarshal(data, &pairs); err != nil {
+		return fmt.Errorf("headers array must contain [name, value] string pairs: %w", err)
+	}
+
+	for _, pair := range pairs {
+		if len(pair) != 2 || pair[0] == nil {
+			return fmt.Errorf("headers array must contain [name, value] string pairs, got %d elements", len(pair))
+		}
+
+		header := JsonLineHeader{Key: *pair[0]}
+		if pair[1] != nil {
+			header.Values = []string{*pair[1]}
+		}
+
+		*headers = append(*headers, header)
+	}
+
+	return nil
+}
+
+func parseHeaderValues(key string, rawValue json.RawMessage) ([]string, error) {
+	var value interface{}
+	if err := json.Unmarshal(rawValue, &value); err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, element := range v {
+			str, ok := element.(string)
+			if !ok {
+				return nil, fmt.Errorf("header %s values must be strings: %v", key, element)
+			}
+			values = append(values, str)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("header %s must be a string, an array of strings or null: %v", key, value)
+	}
+}
+
+// mergeHeaders keeps the static headers in order, except those with a name given in the JSON line,
+// then appends the JSON line headers in the order they were given
+func mergeHeaders(staticHeaders []config.RequestHeader, jsonLineHeaders JsonLineHeaders) []config.RequestHeader {
 	if len(jsonLineHeaders) == 0 {
 		return staticHeaders
 	}
 
-	headersMap := make(map[string]string)
-	for _, header := range staticHeaders {
-		headersMap[header.Key] = header.Value
+	overridden := make(map[string]bool, len(jsonLineHeaders))
+	for _, header := range jsonLineHeaders {
+		overridden[http.CanonicalHeaderKey(header.Key)] = true
 	}
 
-	for key, value := range jsonLineHeaders {
-		headersMap[key] = value
+	mergedHeaders := make([]config.RequestHeader, 0, len(staticHeaders)+len(jsonLineHeaders))
+	for _, header := range staticHeaders {
+		if !overridden[http.CanonicalHeaderKey(header.Key)] {
+			mergedHeaders = append(mergedHeaders, header)
+		}
 	}
 
-	mergedHeaders := make([]config.RequestHeader, 0, len(headersMap))
-	for key, value := range headersMap {
-		mergedHeaders = append(mergedHeaders, config.RequestHeader{Key: key, Value: value})
+	for _, header := range jsonLineHeaders {
+		for _, value := range header.Values {
+			mergedHeaders = append(mergedHeaders, config.RequestHeader{Key: header.Key, Value: value})
+		}
 	}
 
 	return mergedHeaders
 }
 
-func parseBody(bodyType string, body json.RawMessage) (io.ReadCloser, error) {
+// mergeQuery sets each query parameter on the url, replacing any existing values for that name,
+// values can be strings, numbers, booleans or arrays of those for repeated parameters.  The rest of
+// the query is kept as it was given, signed urls can depend on its order and encoding.  A replaced
+// parameter takes the place of its first existing value, new parameters are added in name order
+func mergeQuery(requestUrl *url.URL, query map[string]interface{}) error {
+	if len(query) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(query))
+	merged := make(map[string][]string, len(query))
+	for name, value := range query {
+		parameterValues, err := formatParameterValues("query parameter", name, value)
+		if err != nil {
+			return err
+		}
+
+		for _, parameterValue := range parameterValues {
+			merged[name] = append(merged[name], url.QueryEscape(name)+"="+url.QueryEscape(parameterValue))
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	replaced := make(map[string]bool, len(query))
+	if requestUrl.RawQuery != "" {
+		for _, part := range strings.Split(requestUrl.RawQuery, "&") {
+			key, _, _ := strings.Cut(part, "=")
+			name, err := url.QueryUnescape(key)
+			if err != nil {
+				name = key
+			}
+
+			if _, ok := query[name]; !ok {
+				parts = append(parts, part)
+			} else if !replaced[name] {
+				parts = append(parts, merged[name]...)
+				replaced[name] = true
+			}
+		}
+	}
+
+	for _, name := range names {
+		if !replaced[name] {
+			parts = append(parts, merged[name]...)
+		}
+	}
+
+	requestUrl.RawQuery = strings.Join(parts, "&")
+	return nil
+}
+
+// formats a JSON string, number or boolean, or an array of them for repeated parameters
+func formatParameterValues(kind string, name string, value interface{}) ([]string, error) {
+	array, ok := value.([]interface{})
+	if !ok {
+		array = []interface{}{value}
+	}
+
+	values := make([]string, 0, len(array))
+	for _, element := range array {
+		switch v := element.(type) {
+		case string:
+			values = append(values, v)
+		case float64:
+			values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			values = append(values, strconv.FormatBool(v))
+		default:
+			return nil, fmt.Errorf("unsupported value for %s %s: %v", kind, name, element)
+		}
+	}
+
+	return values, nil
+}
+
+// decodeObjectInOrder calls fn with each key and value of a JSON object in the order they
+// were given, decoding into a map would lose the order
+func decodeObjectInOrder(data []byte, fn func(key string, value json.RawMessage) error) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	if token, err := decoder.Token(); err != nil {
+		return err
+	} else if token != json.Delim('{') {
+		return errors.New("expected a JSON object")
+	}
+
+	for decoder.More() {
+		keyToken, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+
+		if err := fn(keyToken.(string), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseOverrides(jsonLine JsonLine) (*RequestOverrides, error) {
+	if jsonLine.TimeoutMillis == 0 && jsonLine.Retries == nil && jsonLine.OutputFile == "" && jsonLine.FollowRedirects == nil && jsonLine.UnixSocket == "" {
+		return nil, nil
+	}
+
+	if jsonLine.TimeoutMillis < 0 {
+		return nil, fmt.Errorf("timeoutMillis must be positive: %d", jsonLine.TimeoutMillis)
+	}
+
+	if jsonLine.Retries != nil && *jsonLine.Retries < 0 {
+		return nil, fmt.Errorf("retries must not be negative: %d", *jsonLine.Retries)
+	}
+
+	// keep files inside the output directory
+	if jsonLine.OutputFile != "" && !filepath.IsLocal(jsonLine.OutputFile) {
+		return nil, fmt.Errorf("outputFile must be a relative path inside the output directory: %s", jsonLine.OutputFile)
+	}
+
+	return &RequestOverrides{
+		Timeout:         time.Duration(jsonLine.TimeoutMillis) * time.Millisecond,
+		Retries:         jsonLine.Retries,
+		OutputFile:      jsonLine.OutputFile,
+		FollowRedirects: jsonLine.FollowRedirects,
+		UnixSocket:      jsonLine.UnixSocket,
+	}, nil
+}
+
+// the bodies are in memory so createRequest can make them replayable for retries and signing,
+// multipart bodies are set on the request by setMultipartBody as they are streamed when sent
+func parseBody(bodyType string, body json.RawMessage) (io.Reader, error) {
 	switch bodyType {
 	case "escaped":
 		str, err := strconv.Unquote(string(body))
 		if err != nil {
 			return nil, err
 		}
-		return io.NopCloser(strings.NewReader(str)), nil
+		return strings.NewReader(str), nil
 	case "base64":
 		unquoted, err := strconv.Unquote(string(body))
 		if err != nil {
@@ -181,13 +539,84 @@ func parseBody(bodyType string, body json.RawMessage) (io.ReadCloser, error) {
 		if err != nil {
 			return nil, err
 		}
-		return io.NopCloser(bytes.NewReader(data)), nil
+		return bytes.NewReader(data), nil
+	case "form":
+		form, err := encodeForm(body)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(form), nil
+	case "multipart":
+		return nil, nil
 	case "json", "":
 		// Use the JSON as is
-		return io.NopCloser(bytes.NewReader(body)), nil
+		return bytes.NewReader(body), nil
 	default:
-		return nil, fmt.Errorf("unsupported body type: %s, valid values: \"json\", \"base64\", \"escaped\"", bodyType)
+		return nil, fmt.Errorf("unsupported body type: %s, valid values: \"json\", \"base64\", \"escaped\", \"form\", \"multipart\"", bodyType)
+	}
+}
+
+// encodeForm turns a JSON object into an application/x-www-form-urlencoded body, keeping the field order
+func encodeForm(body json.RawMessage) (string, error) {
+	var fields []string
+
+	err := decodeObjectInOrder(body, func(name string, rawValue json.RawMessage) error {
+		var value interface{}
+		if err := json.Unmarshal(rawValue, &value); err != nil {
+			return err
+		}
+
+		values, err := formatParameterValues("form field", name, value)
+		if err != nil {
+			return err
+		}
+
+		for _, value := range values {
+			fields = append(fields, url.QueryEscape(name)+"="+url.QueryEscape(value))
+		}
+		return nil
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("form body must be an object of fields: %w", err)
+	}
+
+	return strings.Join(fields, "&"), nil
+}
+
+// setBodyFile checks the file exists while parsing, but it is only opened by the request worker
+// through GetBody just before sending, and reopened for each retry.  The Content-Type is set
+// from the file extension if no header gave one
+func setBodyFile(request *http.Request, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+
+	request.Body = nil
+	request.ContentLength = info.Size()
+	request.GetBody = func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}
+
+	if request.Header.Get("Content-Type") == "" {
+		if contentType := mime.TypeByExtension(filepath.Ext(path)); contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+	}
+
+	return nil
+}
+
+// the encoding a body file is compressed with, compressBody has already rejected unsupported ones
+func fileEncoding(encoding string) string {
+	if encoding == "identity" {
+		return ""
 	}
+	return encoding
 }
 
 // current assumption is that the first character is '{' for a stream of json lines,
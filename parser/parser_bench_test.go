@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"testing"
@@ -26,7 +27,7 @@ func BenchmarkSendUrlsRequests(b *testing.B) {
 			}
 		}()
 
-		SendRequests(ch, input, "GET", headers)
+		SendRequests(context.Background(), ch, input, "GET", headers, "")
 		close(ch)
 	}
 }
@@ -47,7 +48,7 @@ func BenchmarkSendJsonLinesRequests(b *testing.B) {
 			}
 		}()
 
-		SendRequests(ch, input, "POST", headers)
+		SendRequests(context.Background(), ch, input, "POST", headers, "")
 		close(ch)
 	}
 }
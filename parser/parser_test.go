@@ -1,13 +1,22 @@
 package parser_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/tednaleid/ganda/config"
 	"github.com/tednaleid/ganda/parser"
 	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSendGetRequestUrlsHaveDefaultHeaders(t *testing.T) {
@@ -21,7 +30,7 @@ func TestSendGetRequestUrlsHaveDefaultHeaders(t *testing.T) {
 
 	var in = trimmedInputReader(inputLines)
 
-	err := parser.SendRequests(requestsWithContext, in, "GET", []config.RequestHeader{})
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", []config.RequestHeader{}, "")
 
 	assert.Nil(t, err, "expected no error")
 
@@ -51,7 +60,7 @@ func TestSendGetRequestUrlsAddGivenHeaders(t *testing.T) {
 
 	requestHeaders := []config.RequestHeader{{Key: "X-Test", Value: "foo"}, {Key: "X-Test2", Value: "bar"}}
 
-	err := parser.SendRequests(requestsWithContext, in, "GET", requestHeaders)
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", requestHeaders, "")
 
 	assert.Nil(t, err, "expected no error")
 
@@ -83,7 +92,7 @@ func TestSendRequestsHasRaggedRequestContext(t *testing.T) {
 
 	var in = trimmedInputReader(inputLines)
 
-	parser.SendRequests(requestsWithContext, in, "GET", []config.RequestHeader{})
+	parser.SendRequests(context.Background(), requestsWithContext, in, "GET", []config.RequestHeader{}, "")
 
 	expectedResults := []struct {
 		url     string
@@ -112,7 +121,7 @@ func TestSendRequestsHasMalformedTSV(t *testing.T) {
 
 	var in = trimmedInputReader(inputLines)
 
-	err := parser.SendRequests(requestsWithContext, in, "GET", []config.RequestHeader{})
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", []config.RequestHeader{}, "")
 
 	assert.NotNil(t, err, "expected error")
 	assert.Equal(t, "parse error on line 1, column 65: extraneous or missing \" in quoted-field", err.Error())
@@ -130,7 +139,7 @@ func TestSendJsonLinesRequests(t *testing.T) {
 
 	var in = trimmedInputReader(inputLines)
 
-	err := parser.SendRequests(requestsWithContext, in, "GET", []config.RequestHeader{})
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", []config.RequestHeader{}, "")
 	assert.Nil(t, err, "expected no error")
 
 	expectedResults := []struct {
@@ -163,7 +172,7 @@ func TestSendJsonLinesRequestsMissingUrl(t *testing.T) {
 
 	var in = trimmedInputReader(inputLines)
 
-	err := parser.SendRequests(requestsWithContext, in, "GET", []config.RequestHeader{})
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", []config.RequestHeader{}, "")
 
 	assert.NotNil(t, err, "expected error")
 	assert.Equal(t, "missing url property: { \"noturl\": \"https://ex.com/bar\", \"context\": [\"foo\", \"quoted content\"] }", err.Error())
@@ -178,7 +187,7 @@ func TestSendJsonLinesRequestsMalformedJson(t *testing.T) {
 
 	var in = trimmedInputReader(inputLines)
 
-	err := parser.SendRequests(requestsWithContext, in, "GET", []config.RequestHeader{})
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", []config.RequestHeader{}, "")
 
 	assert.NotNil(t, err, "expected error")
 	assert.Equal(t, "unexpected end of JSON input: { \"url\": \"https://ex.com/bar\", \"context\": [\"foo\", \"quoted content\"]", err.Error())
@@ -194,7 +203,7 @@ func TestSendJsonLinesAddGivenHeaders(t *testing.T) {
 
 	staticHeaders := []config.RequestHeader{{Key: "X-Static", Value: "foo"}}
 
-	err := parser.SendRequests(requestsWithContext, in, "GET", staticHeaders)
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", staticHeaders, "")
 
 	assert.Nil(t, err, "expected no error")
 
@@ -220,7 +229,7 @@ func TestSendJsonLinesGivenHeadersOverrideStaticHeaders(t *testing.T) {
 
 	staticHeaders := []config.RequestHeader{{Key: "X-Bar", Value: "foo"}}
 
-	err := parser.SendRequests(requestsWithContext, in, "GET", staticHeaders)
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", staticHeaders, "")
 
 	assert.Nil(t, err, "expected no error")
 
@@ -243,7 +252,7 @@ func TestSendJsonLinesStaticHeadersAreNotRequiredToAddHeaders(t *testing.T) {
 
 	var in = trimmedInputReader(inputLines)
 
-	err := parser.SendRequests(requestsWithContext, in, "GET", nil)
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", nil, "")
 
 	assert.Nil(t, err, "expected no error")
 
@@ -258,6 +267,78 @@ func TestSendJsonLinesStaticHeadersAreNotRequiredToAddHeaders(t *testing.T) {
 	assert.Equal(t, "baz", requestContext, "expected context")
 }
 
+func TestSendJsonLinesMultiValuedHeaders(t *testing.T) {
+	testCases := []struct {
+		name    string
+		headers string
+	}{
+		{"object with array values", `{ "Accept": ["text/html", "application/json"], "Cookie": "a=1" }`},
+		{"pair list", `[["Accept", "text/html"], ["Cookie", "a=1"], ["accept", "application/json"]]`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requestsWithContext := make(chan parser.RequestWithContext, 1)
+			defer close(requestsWithContext)
+
+			in := strings.NewReader(`{ "url": "https://ex.com/123", "headers": ` + tc.headers + ` }`)
+
+			err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", nil, "")
+
+			assert.Nil(t, err, "expected no error")
+
+			request := (<-requestsWithContext).Request
+			assert.Equal(t, []string{"text/html", "application/json"}, request.Header["Accept"])
+			assert.Equal(t, []string{"a=1"}, request.Header["Cookie"])
+		})
+	}
+}
+
+func TestSendJsonLinesHeadersReplaceAndRemoveStaticHeaders(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	in := strings.NewReader(`{ "url": "https://ex.com/123", "headers": { "X-Bar": ["corge", "grault"], "x-remove": null } }`)
+
+	staticHeaders := []config.RequestHeader{
+		{Key: "x-bar", Value: "foo"},
+		{Key: "X-Remove", Value: "secret"},
+		{Key: "X-Multi", Value: "one"},
+		{Key: "X-Multi", Value: "two"},
+	}
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", staticHeaders, "")
+
+	assert.Nil(t, err, "expected no error")
+
+	request := (<-requestsWithContext).Request
+	assert.Equal(t, []string{"corge", "grault"}, request.Header["X-Bar"], "JSON line values replace static values")
+	assert.NotContains(t, request.Header, "X-Remove", "null removes the static header")
+	assert.Equal(t, []string{"one", "two"}, request.Header["X-Multi"], "repeated static headers are kept in order")
+}
+
+func TestSendJsonLinesInvalidHeaders(t *testing.T) {
+	testCases := []struct {
+		headers string
+		error   string
+	}{
+		{`"X-Foo: bar"`, "headers must be an object or an array of [name, value] pairs"},
+		{`{ "X-Foo": 1 }`, "header X-Foo must be a string, an array of strings or null: 1"},
+		{`{ "X-Foo": ["a", 1] }`, "header X-Foo values must be strings: 1"},
+		{`[["X-Foo"]]`, "headers array must contain [name, value] string pairs, got 1 elements"},
+	}
+
+	for _, tc := range testCases {
+		requestsWithContext := make(chan parser.RequestWithContext, 1)
+
+		line := `{ "url": "https://ex.com/123", "headers": ` + tc.headers + ` }`
+		err := parser.SendRequests(context.Background(), requestsWithContext, strings.NewReader(line), "GET", nil, "")
+
+		assert.EqualError(t, err, tc.error+": "+line)
+		close(requestsWithContext)
+	}
+}
+
 func TestSendJsonLinesPassesBody(t *testing.T) {
 	requestsWithContext := make(chan parser.RequestWithContext, 3)
 	defer close(requestsWithContext)
@@ -279,7 +360,7 @@ func TestSendJsonLinesPassesBody(t *testing.T) {
 
 		var in = strings.NewReader(inputLines)
 
-		err := parser.SendRequests(requestsWithContext, in, "GET", nil)
+		err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", nil, "")
 
 		assert.Nil(t, err, "expected no error")
 
@@ -293,14 +374,421 @@ func TestSendJsonLinesPassesBody(t *testing.T) {
 		bodyString := string(bodyBytes)
 
 		assert.Equal(t, bodyInput.expected, bodyString, "expected body")
+		assert.Equal(t, int64(len(bodyInput.expected)), request.ContentLength, "expected Content-Length")
+
+		// retries and request signing read the body again
+		body, err := request.GetBody()
+		assert.NoError(t, err)
+		bodyBytes, _ = io.ReadAll(body)
+		assert.Equal(t, bodyInput.expected, string(bodyBytes), "expected replayable body")
+	}
+}
+
+func TestSendJsonLinesMergesQuery(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	in := strings.NewReader(`{ "url": "https://ex.com/123?a=1&b=2", "query": { "b": "replaced", "c": 3.5, "d": true, "e": ["x", "y"] } }`)
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", nil, "")
+
+	assert.Nil(t, err, "expected no error")
+
+	requestWithContext := <-requestsWithContext
+	assert.Equal(t, "https://ex.com/123?a=1&b=replaced&c=3.5&d=true&e=x&e=y", requestWithContext.Request.URL.String())
+	assert.Nil(t, requestWithContext.Overrides, "query isn't an override of a batch setting")
+}
+
+func TestSendJsonLinesMergeQueryKeepsTheRestOfTheQuery(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	// the other parameters keep their order, encoding and repeats, a replaced parameter stays in place
+	in := strings.NewReader(`{ "url": "https://ex.com/123?z=a%20b&sig=A%2Fb&tag=1&x=&tag=2&q=c+d", "query": { "tag": ["new"], "added": "a b", "sig2": [] } }`)
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", nil, "")
+
+	assert.Nil(t, err, "expected no error")
+
+	requestWithContext := <-requestsWithContext
+	assert.Equal(t, "z=a%20b&sig=A%2Fb&tag=new&x=&q=c+d&added=a+b", requestWithContext.Request.URL.RawQuery)
+}
+
+func TestSendJsonLinesInvalidQueryValue(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	in := strings.NewReader(`{ "url": "https://ex.com/123", "query": { "a": { "nested": 1 } } }`)
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", nil, "")
+
+	assert.EqualError(t, err, "invalid query for https://ex.com/123: unsupported value for query parameter a: map[nested:1]")
+}
+
+func TestSendJsonLinesOverrides(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 2)
+	defer close(requestsWithContext)
+
+	inputLines := `
+		{ "url": "https://ex.com/123", "timeoutMillis": 2500, "retries": 0, "outputFile": "sub/123.json", "followRedirects": false, "unixSocket": "/var/run/docker.sock" }
+		{ "url": "https://ex.com/456" }
+	`
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, trimmedInputReader(inputLines), "GET", nil, "")
+
+	assert.Nil(t, err, "expected no error")
+
+	overrides := (<-requestsWithContext).Overrides
+	assert.NotNil(t, overrides)
+	assert.Equal(t, 2500*time.Millisecond, overrides.Timeout)
+	assert.Equal(t, 0, *overrides.Retries)
+	assert.Equal(t, "sub/123.json", overrides.OutputFile)
+	assert.Equal(t, false, *overrides.FollowRedirects)
+	assert.Equal(t, "/var/run/docker.sock", overrides.UnixSocket)
+
+	assert.Nil(t, (<-requestsWithContext).Overrides, "lines without overrides use the batch settings")
+}
+
+func TestSendJsonLinesInvalidOverrides(t *testing.T) {
+	testCases := []struct {
+		line  string
+		error string
+	}{
+		{`{ "url": "https://ex.com/1", "timeoutMillis": -1 }`, "invalid request for https://ex.com/1: timeoutMillis must be positive: -1"},
+		{`{ "url": "https://ex.com/1", "retries": -1 }`, "invalid request for https://ex.com/1: retries must not be negative: -1"},
+		{`{ "url": "https://ex.com/1", "outputFile": "../escape.json" }`, "invalid request for https://ex.com/1: outputFile must be a relative path inside the output directory: ../escape.json"},
+		{`{ "url": "https://ex.com/1", "outputFile": "/etc/passwd" }`, "invalid request for https://ex.com/1: outputFile must be a relative path inside the output directory: /etc/passwd"},
+	}
+
+	for _, tc := range testCases {
+		requestsWithContext := make(chan parser.RequestWithContext, 1)
+
+		err := parser.SendRequests(context.Background(), requestsWithContext, strings.NewReader(tc.line), "GET", nil, "")
+
+		assert.EqualError(t, err, tc.error)
+		close(requestsWithContext)
+	}
+}
+
+func TestSendJsonLinesBodyFile(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	bodyFile := filepath.Join(t.TempDir(), "doc.json")
+	os.WriteFile(bodyFile, []byte(`{"key": "value"}`), 0o600)
+
+	in := strings.NewReader(`{ "url": "https://ex.com/123", "method": "PUT", "bodyFile": "` + bodyFile + `" }`)
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", nil, "")
+
+	assert.Nil(t, err, "expected no error")
+
+	request := (<-requestsWithContext).Request
+	assert.Nil(t, request.Body, "file isn't opened until the request is sent")
+	assert.Equal(t, int64(16), request.ContentLength)
+	assert.Equal(t, "application/json", request.Header.Get("Content-Type"))
+
+	for range 2 {
+		body, err := request.GetBody()
+		assert.NoError(t, err)
+		bodyBytes, _ := io.ReadAll(body)
+		body.Close()
+		assert.Equal(t, `{"key": "value"}`, string(bodyBytes), "each attempt reads the whole file")
+	}
+}
+
+func TestSendJsonLinesBodyFileKeepsGivenContentType(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	bodyFile := filepath.Join(t.TempDir(), "doc.json")
+	os.WriteFile(bodyFile, []byte(`{}`), 0o600)
+
+	in := strings.NewReader(`{ "url": "https://ex.com/123", "headers": { "Content-Type": "application/vnd.custom+json" }, "bodyFile": "` + bodyFile + `" }`)
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", nil, "")
+
+	assert.Nil(t, err, "expected no error")
+	assert.Equal(t, "application/vnd.custom+json", (<-requestsWithContext).Request.Header.Get("Content-Type"))
+}
+
+func TestSendJsonLinesInvalidBodyFile(t *testing.T) {
+	testCases := []struct {
+		line  string
+		error string
+	}{
+		{`{ "url": "https://ex.com/1", "bodyFile": "does-not-exist.json" }`, "invalid body file for https://ex.com/1: stat does-not-exist.json: no such file or directory"},
+		{`{ "url": "https://ex.com/1", "bodyFile": "." }`, "invalid body file for https://ex.com/1: . is a directory"},
+		{`{ "url": "https://ex.com/1", "bodyFile": ".", "body": {} }`, `only one of body and bodyFile can be given: { "url": "https://ex.com/1", "bodyFile": ".", "body": {} }`},
+	}
+
+	for _, tc := range testCases {
+		requestsWithContext := make(chan parser.RequestWithContext, 1)
+
+		err := parser.SendRequests(context.Background(), requestsWithContext, strings.NewReader(tc.line), "GET", nil, "")
+
+		assert.EqualError(t, err, tc.error)
+		close(requestsWithContext)
+	}
+}
+
+func TestSendUrlsRequestsBodyFile(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	bodyFile := filepath.Join(t.TempDir(), "doc.txt")
+	os.WriteFile(bodyFile, []byte("the body"), 0o600)
+
+	in := strings.NewReader("https://ex.com/123\t@file:" + bodyFile + "\tfoo\n")
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "POST", nil, "")
+
+	assert.Nil(t, err, "expected no error")
+
+	requestWithContext := <-requestsWithContext
+	assert.Equal(t, []string{"foo"}, requestWithContext.RequestContext, "body file isn't part of the context")
+	assert.Equal(t, int64(8), requestWithContext.Request.ContentLength)
+	assert.Equal(t, "text/plain; charset=utf-8", requestWithContext.Request.Header.Get("Content-Type"))
+}
+
+func TestSendUrlsRequestsAtPathIsContextNotABodyFile(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 2)
+	defer close(requestsWithContext)
+
+	// only @file:PATH is a body file, a plain @path is context even when the file exists
+	bodyFile := filepath.Join(t.TempDir(), "doc.txt")
+	os.WriteFile(bodyFile, []byte("the body"), 0o600)
+
+	in := strings.NewReader("https://ex.com/123\t@" + bodyFile + "\tfoo\nhttps://ex.com/456\t@alice\n")
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "POST", nil, "")
+
+	assert.Nil(t, err, "expected no error")
+
+	requestWithContext := <-requestsWithContext
+	assert.Equal(t, []string{"@" + bodyFile, "foo"}, requestWithContext.RequestContext)
+	assert.Nil(t, requestWithContext.Request.GetBody, "no body is sent")
+	assert.Equal(t, int64(0), requestWithContext.Request.ContentLength)
+
+	requestWithContext = <-requestsWithContext
+	assert.Equal(t, []string{"@alice"}, requestWithContext.RequestContext)
+	assert.Nil(t, requestWithContext.Request.GetBody, "no body is sent")
+}
+
+func TestSendJsonLinesFormBody(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	in := strings.NewReader(`{ "url": "https://ex.com/123", "method": "POST", "bodyType": "form", "body": { "name": "a b&c", "count": 2, "tag": ["x", "y"], "ok": true } }`)
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", nil, "")
+
+	assert.Nil(t, err, "expected no error")
+
+	request := (<-requestsWithContext).Request
+	bodyBytes, _ := io.ReadAll(request.Body)
+	assert.Equal(t, "name=a+b%26c&count=2&tag=x&tag=y&ok=true", string(bodyBytes), "fields are kept in order")
+	assert.Equal(t, "application/x-www-form-urlencoded", request.Header.Get("Content-Type"))
+}
+
+func TestSendJsonLinesInvalidFormBody(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	in := strings.NewReader(`{ "url": "https://ex.com/123", "bodyType": "form", "body": { "nested": { "a": 1 } } }`)
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", nil, "")
+
+	assert.EqualError(t, err, "failed to parse body: form body must be an object of fields: unsupported value for form field nested: map[a:1]")
+}
+
+func TestSendJsonLinesMultipartBody(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	directory := t.TempDir()
+	reportFile := filepath.Join(directory, "report.json")
+	os.WriteFile(reportFile, []byte(`{"total": 42}`), 0o600)
+	imageFile := filepath.Join(directory, "image.bin")
+	os.WriteFile(imageFile, []byte{0, 1, 2}, 0o600)
+
+	body := `{ "title": "Q3", "tag": ["a", "b"], "report": { "file": "` + reportFile + `" }, ` +
+		`"image": { "file": "` + imageFile + `", "filename": "pic \"1\".png", "contentType": "image/png" } }`
+	in := strings.NewReader(`{ "url": "https://ex.com/upload", "method": "POST", "bodyType": "multipart", "body": ` + body + ` }`)
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", nil, "")
+
+	assert.Nil(t, err, "expected no error")
+
+	request := (<-requestsWithContext).Request
+	mediaType, params, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", mediaType)
+
+	for range 2 { // every attempt sends the same body
+		body, err := request.GetBody()
+		assert.NoError(t, err)
+		bodyBytes, _ := io.ReadAll(body)
+		body.Close()
+		assert.Equal(t, request.ContentLength, int64(len(bodyBytes)), "Content-Length matches the streamed body")
+
+		reader := multipart.NewReader(bytes.NewReader(bodyBytes), params["boundary"])
+		expectedParts := []struct {
+			name        string
+			filename    string
+			contentType string
+			content     string
+		}{
+			{"title", "", "", "Q3"},
+			{"tag", "", "", "a"},
+			{"tag", "", "", "b"},
+			{"report", "report.json", "application/json", `{"total": 42}`},
+			{"image", `pic "1".png`, "image/png", "\x00\x01\x02"},
+		}
+
+		for _, expected := range expectedParts {
+			part, err := reader.NextPart()
+			assert.NoError(t, err)
+			content, _ := io.ReadAll(part)
+			assert.Equal(t, expected.name, part.FormName())
+			assert.Equal(t, expected.filename, part.FileName())
+			assert.Equal(t, expected.contentType, part.Header.Get("Content-Type"))
+			assert.Equal(t, expected.content, string(content))
+		}
+
+		_, err = reader.NextPart()
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+func TestSendJsonLinesInvalidMultipartBody(t *testing.T) {
+	testCases := []struct {
+		body  string
+		error string
+	}{
+		{`{ "doc": { "filename": "a.txt" } }`, "failed to parse body: multipart body must be an object of fields: multipart file part doc is missing the file property"},
+		{`{ "doc": { "file": "does-not-exist.txt" } }`, "failed to parse body: multipart body must be an object of fields: stat does-not-exist.txt: no such file or directory"},
+		{`"not an object"`, "failed to parse body: multipart body must be an object of fields: expected a JSON object"},
 	}
+
+	for _, tc := range testCases {
+		requestsWithContext := make(chan parser.RequestWithContext, 1)
+
+		line := `{ "url": "https://ex.com/upload", "bodyType": "multipart", "body": ` + tc.body + ` }`
+		err := parser.SendRequests(context.Background(), requestsWithContext, strings.NewReader(line), "GET", nil, "")
+
+		assert.EqualError(t, err, tc.error)
+		close(requestsWithContext)
+	}
+}
+
+func TestSendJsonLinesCompressesBody(t *testing.T) {
+	testCases := []struct {
+		bodyEncoding   string
+		lineEncoding   string
+		expectedHeader string
+	}{
+		{"gzip", "", "gzip"},
+		{"zstd", "", "zstd"},
+		{"", "gzip", "gzip"},
+		{"gzip", "zstd", "zstd"},
+		{"gzip", "identity", ""},
+		{"", "", ""},
+	}
+
+	for _, tc := range testCases {
+		requestsWithContext := make(chan parser.RequestWithContext, 1)
+
+		line := `{ "url": "https://ex.com/123", "method": "POST", "body": {"key": "value"}, "bodyEncoding": "` + tc.lineEncoding + `" }`
+		err := parser.SendRequests(context.Background(), requestsWithContext, strings.NewReader(line), "GET", nil, tc.bodyEncoding)
+
+		assert.Nil(t, err, "expected no error")
+
+		request := (<-requestsWithContext).Request
+		assert.Equal(t, tc.expectedHeader, request.Header.Get("Content-Encoding"))
+
+		if tc.expectedHeader == "" {
+			assert.Equal(t, `{"key": "value"}`, decompress(t, "", request.Body))
+		} else {
+			for range 2 { // every attempt sends the same body
+				body, err := request.GetBody()
+				assert.NoError(t, err)
+				assert.Equal(t, `{"key": "value"}`, decompress(t, tc.expectedHeader, body))
+			}
+		}
+		close(requestsWithContext)
+	}
+}
+
+func TestSendJsonLinesCompressesBodyFile(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	bodyFile := filepath.Join(t.TempDir(), "doc.json")
+	os.WriteFile(bodyFile, []byte(`{"key": "value"}`), 0o600)
+
+	in := strings.NewReader(`{ "url": "https://ex.com/123", "method": "PUT", "bodyFile": "` + bodyFile + `" }`)
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", nil, "gzip")
+
+	assert.Nil(t, err, "expected no error")
+
+	request := (<-requestsWithContext).Request
+	assert.Equal(t, "gzip", request.Header.Get("Content-Encoding"))
+	assert.Equal(t, "application/json", request.Header.Get("Content-Type"))
+	assert.Equal(t, int64(-1), request.ContentLength, "streamed bodies are sent chunked")
+
+	body, err := request.GetBody()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"key": "value"}`, decompress(t, "gzip", body))
+}
+
+func TestSendJsonLinesDoesNotCompressEmptyBody(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	err := parser.SendRequests(context.Background(), requestsWithContext, strings.NewReader(`{ "url": "https://ex.com/123" }`), "GET", nil, "gzip")
+
+	assert.Nil(t, err, "expected no error")
+	assert.Equal(t, "", (<-requestsWithContext).Request.Header.Get("Content-Encoding"))
+}
+
+func TestSendJsonLinesInvalidBodyEncoding(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	line := `{ "url": "https://ex.com/123", "body": {}, "bodyEncoding": "br" }`
+	err := parser.SendRequests(context.Background(), requestsWithContext, strings.NewReader(line), "GET", nil, "")
+
+	assert.EqualError(t, err, `failed to compress body for https://ex.com/123: unsupported body encoding: br, valid values: "gzip", "zstd", "identity"`)
+}
+
+func decompress(t *testing.T, encoding string, body io.ReadCloser) string {
+	defer body.Close()
+
+	var reader io.Reader = body
+	switch encoding {
+	case "gzip":
+		gzipReader, err := gzip.NewReader(body)
+		assert.NoError(t, err)
+		reader = gzipReader
+	case "zstd":
+		zstdReader, err := zstd.NewReader(body)
+		assert.NoError(t, err)
+		defer zstdReader.Close()
+		reader = zstdReader
+	}
+
+	decompressed, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	return string(decompressed)
 }
 
 func TestEmptyInputReturnsNoError(t *testing.T) {
 	requestsWithContext := make(chan parser.RequestWithContext, 1)
 	defer close(requestsWithContext)
 
-	err := parser.SendRequests(requestsWithContext, strings.NewReader(""), "GET", []config.RequestHeader{})
+	err := parser.SendRequests(context.Background(), requestsWithContext, strings.NewReader(""), "GET", []config.RequestHeader{}, "")
 
 	assert.Nil(t, err, "empty input should not return an error")
 	assert.Equal(t, 0, len(requestsWithContext), "no requests should be sent")
@@ -312,7 +800,7 @@ func TestMalformedUrlReturnsError(t *testing.T) {
 
 	in := strings.NewReader("://bad-url\n")
 
-	err := parser.SendRequests(requestsWithContext, in, "GET", []config.RequestHeader{})
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", []config.RequestHeader{}, "")
 
 	assert.NotNil(t, err, "malformed URL should return an error")
 	assert.Contains(t, err.Error(), "invalid request")
@@ -324,12 +812,30 @@ func TestMalformedJsonLineUrlReturnsError(t *testing.T) {
 
 	in := strings.NewReader(`{"url": "://bad-url"}` + "\n")
 
-	err := parser.SendRequests(requestsWithContext, in, "GET", []config.RequestHeader{})
+	err := parser.SendRequests(context.Background(), requestsWithContext, in, "GET", []config.RequestHeader{}, "")
 
 	assert.NotNil(t, err, "malformed URL in JSON line should return an error")
 	assert.Contains(t, err.Error(), "invalid request")
 }
 
+func TestCancelledContextStopsSendingRequests(t *testing.T) {
+	requestsWithContext := make(chan parser.RequestWithContext, 1)
+	defer close(requestsWithContext)
+
+	in := trimmedInputReader(`
+        https://example.com/bar
+        https://example.com/qux
+    `)
+
+	cancelledContext, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := parser.SendRequests(cancelledContext, requestsWithContext, in, "GET", []config.RequestHeader{}, "")
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, len(requestsWithContext), "no requests should be sent")
+}
+
 func trimmedInputReader(s string) io.Reader {
 	lines := strings.Split(s, "\n")
 	var trimmedLines []string
@@ -0,0 +1,120 @@
+package requests
+
+import (
+	"fmt"
+	"sync"
+)
+
+// FailureTracker is shared by the request workers, it counts requests that failed after
+// exhausting their retries and calls abort once the run has failed too many times, either
+// in total (maxFailures) or as a fraction of the most recent failureWindow requests (maxFailureRate)
+type FailureTracker struct {
+	mutex          sync.Mutex
+	maxFailures    int
+	maxFailureRate float64
+	window         []bool // ring buffer of the most recent outcomes, true for a failure
+	windowNext     int
+	windowFailures int
+	completed      int
+	failures       int
+	abort          func()
+	err            error
+	abortedAfter   int // the requests completed when err was set, the err's counts are from then too
+}
+
+// NewFailureTracker returns nil if neither limit is enabled, a nil tracker records nothing
+func NewFailureTracker(maxFailures int, maxFailureRate float64, failureWindow int, abort func()) *FailureTracker {
+	if maxFailures <= 0 && maxFailureRate <= 0 {
+		return nil
+	}
+
+	tracker := &FailureTracker{
+		maxFailures:    maxFailures,
+		maxFailureRate: maxFailureRate,
+		abort:          abort,
+	}
+
+	if maxFailureRate > 0 {
+		tracker.window = make([]bool, 0, failureWindow)
+	}
+
+	return tracker
+}
+
+// Record the outcome of a completed request, aborting the run the first time a limit is exceeded
+func (t *FailureTracker) Record(failed bool) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.completed++
+	if failed {
+		t.failures++
+	}
+
+	if t.maxFailureRate > 0 {
+		t.recordInWindow(failed)
+	}
+
+	if t.err != nil {
+		return
+	}
+
+	if t.maxFailures > 0 && t.failures >= t.maxFailures {
+		t.err = fmt.Errorf("%d of %d requests failed, reached --max-failures %d", t.failures, t.completed, t.maxFailures)
+	} else if t.maxFailureRate > 0 && len(t.window) == cap(t.window) {
+		rate := float64(t.windowFailures) / float64(len(t.window))
+		if rate >= t.maxFailureRate {
+			t.err = fmt.Errorf("%d of the last %d requests failed, reached --max-failure-rate %g", t.windowFailures, len(t.window), t.maxFailureRate)
+		}
+	}
+
+	if t.err != nil {
+		t.abortedAfter = t.completed
+		if t.abort != nil {
+			t.abort()
+		}
+	}
+}
+
+func (t *FailureTracker) recordInWindow(failed bool) {
+	if len(t.window) < cap(t.window) {
+		t.window = append(t.window, failed)
+	} else {
+		if t.window[t.windowNext] {
+			t.windowFailures--
+		}
+		t.window[t.windowNext] = failed
+		t.windowNext = (t.windowNext + 1) % len(t.window)
+	}
+
+	if failed {
+		t.windowFailures++
+	}
+}
+
+// Err returns a description of the exceeded limit if the run was aborted, otherwise nil
+func (t *FailureTracker) Err() error {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.err
+}
+
+// AbortedAfter returns the number of requests that had completed when the run was aborted, the
+// counts in Err are from the same moment.  Requests in flight then finish after it
+func (t *FailureTracker) AbortedAfter() int {
+	if t == nil {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.abortedAfter
+}
@@ -0,0 +1,64 @@
+package requests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFailureTrackerWithoutLimitsIsNil(t *testing.T) {
+	tracker := NewFailureTracker(0, 0, 100, func() { t.Fatal("should not abort") })
+
+	assert.Nil(t, tracker)
+	tracker.Record(true) // a nil tracker ignores outcomes
+	assert.NoError(t, tracker.Err())
+	assert.Equal(t, 0, tracker.AbortedAfter())
+}
+
+func TestFailureTrackerMaxFailures(t *testing.T) {
+	aborts := 0
+	tracker := NewFailureTracker(2, 0, 100, func() { aborts++ })
+
+	tracker.Record(true)
+	tracker.Record(false)
+	assert.NoError(t, tracker.Err())
+	assert.Equal(t, 0, aborts)
+
+	tracker.Record(true)
+	assert.EqualError(t, tracker.Err(), "2 of 3 requests failed, reached --max-failures 2")
+	assert.Equal(t, 1, aborts)
+
+	tracker.Record(true)
+	assert.Equal(t, 1, aborts, "abort is only called once")
+	assert.EqualError(t, tracker.Err(), "2 of 3 requests failed, reached --max-failures 2", "the counts are from when it aborted")
+	assert.Equal(t, 3, tracker.AbortedAfter())
+}
+
+func TestFailureTrackerMaxFailureRateWaitsForFullWindow(t *testing.T) {
+	aborts := 0
+	tracker := NewFailureTracker(0, 0.5, 4, func() { aborts++ })
+
+	tracker.Record(true)
+	tracker.Record(true)
+	tracker.Record(true)
+	assert.NoError(t, tracker.Err(), "window isn't full yet")
+
+	tracker.Record(false)
+	assert.EqualError(t, tracker.Err(), "3 of the last 4 requests failed, reached --max-failure-rate 0.5")
+	assert.Equal(t, 1, aborts)
+}
+
+func TestFailureTrackerMaxFailureRateSlidesWindow(t *testing.T) {
+	aborts := 0
+	tracker := NewFailureTracker(0, 0.75, 4, func() { aborts++ })
+
+	for _, failed := range []bool{true, true, false, false, false, true, true} {
+		tracker.Record(failed)
+	}
+	// last 4 are false, false, true, true
+	assert.NoError(t, tracker.Err())
+
+	tracker.Record(true)
+	assert.EqualError(t, tracker.Err(), "3 of the last 4 requests failed, reached --max-failure-rate 0.75")
+	assert.Equal(t, 1, aborts)
+}
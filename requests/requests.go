@@ -1,13 +1,19 @@
 package requests
 
 import (
-	"crypto/tls"
+	ctx "context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/tednaleid/ganda/execcontext"
 	"github.com/tednaleid/ganda/logger"
 	"github.com/tednaleid/ganda/parser"
 	"github.com/tednaleid/ganda/responses"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,40 +22,210 @@ type HttpClient struct {
 	MaxRetries int
 	Client     *http.Client
 	Logger     *logger.LeveledLogger
+
+	authenticator       execcontext.Authenticator                  // supplies the Authorization header for requests without one
+	signer              execcontext.Signer                         // signs each attempt just before it's sent
+	netrc               *execcontext.Netrc                         // per-host credentials for requests without an Authorization header
+	followRedirects     func(*http.Request, []*http.Request) error // the --max-redirects and --same-host-redirects policy
+	recordSourceAddress bool                                       // the local address of each connection is added to its response
+	recordProtocol      bool                                       // the protocol of each response is added to the JSON envelope
+	stats               *RunStats
+	pool                *connectionPool // shared with the other workers using it, Client.Transport wraps it for --verbose
+}
+
+// connectionPool is the transport shared by a set of workers, along with a transport for each
+// unix socket their requests override it with as pooled connections are only keyed by scheme and host
+type connectionPool struct {
+	transport *http.Transport
+
+	mutex       sync.Mutex
+	unixSockets map[string]*http.Transport // by socket path, created on the first request that uses it
+}
+
+func newConnectionPool(context *execcontext.Context) *connectionPool {
+	return &connectionPool{transport: NewTransport(context)}
+}
+
+// unixSocketTransport returns the pool's transport for the socket, cloning the pool's settings the first time
+func (pool *connectionPool) unixSocketTransport(unixSocket string) *http.Transport {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if transport, ok := pool.unixSockets[unixSocket]; ok {
+		return transport
+	}
+
+	transport := pool.transport.Clone()
+	useUnixSocket(transport, unixSocket)
+
+	if pool.unixSockets == nil {
+		pool.unixSockets = make(map[string]*http.Transport)
+	}
+	pool.unixSockets[unixSocket] = transport
+
+	return transport
 }
 
-func NewHttpClient(context *execcontext.Context) *HttpClient {
-	return &HttpClient{
+// NewTransport returns a connection pool that can be shared by request workers,
+// the limits apply across every worker using it
+func NewTransport(context *execcontext.Context) *http.Transport {
+	transport := &http.Transport{
+		Proxy:               context.Proxy,
+		DialContext:         context.Dialer.DialContext,
+		MaxIdleConns:        context.MaxIdleConns,
+		MaxIdleConnsPerHost: context.MaxIdleConns,
+		MaxConnsPerHost:     context.MaxConnsPerHost,
+		IdleConnTimeout:     context.IdleConnTimeout,
+		ForceAttemptHTTP2:   true,
+		TLSClientConfig:     context.TLSConfig.Clone(),
+	}
+
+	if context.HTTPProtocols != nil {
+		transport.Protocols = context.HTTPProtocols
+		transport.ForceAttemptHTTP2 = false
+	}
+
+	if context.UnixSocket != "" {
+		useUnixSocket(transport, context.UnixSocket)
+	}
+
+	return transport
+}
+
+func newHttpClient(context *execcontext.Context, pool *connectionPool, stats *RunStats) *HttpClient {
+	followRedirects := redirectPolicy(context.MaxRedirects, context.SameHostRedirects)
+	checkRedirect := followRedirects
+	if context.NoFollowRedirects {
+		checkRedirect = noFollowRedirects
+	}
+
+	httpClient := &HttpClient{
 		MaxRetries: context.Retries,
 		Logger:     context.Logger,
 		Client: &http.Client{
-			Timeout: context.ConnectTimeoutDuration,
-			Transport: &http.Transport{
-				MaxIdleConns:        500,
-				MaxIdleConnsPerHost: 50,
-				MaxConnsPerHost:     50,
-				IdleConnTimeout:     90 * time.Second,
-				ForceAttemptHTTP2:   true,
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: context.Insecure,
-				},
-			},
+			Timeout:       context.ConnectTimeoutDuration,
+			Transport:     pool.transport,
+			CheckRedirect: checkRedirect,
 		},
+		pool:                pool,
+		authenticator:       context.Authenticator,
+		netrc:               context.Netrc,
+		signer:              context.Signer,
+		followRedirects:     followRedirects,
+		recordSourceAddress: context.Dialer.BindsSourceAddress(),
+		recordProtocol:      context.HTTPProtocols != nil,
+		stats:               stats,
+	}
+
+	if context.Logger.Verbosity() > 0 {
+		httpClient.Client.Transport = &verboseTransport{transport: pool.transport, logger: context.Logger}
+	}
+
+	// all workers share the jar so a cookie set by one response is sent by every worker
+	if context.CookieJar != nil {
+		httpClient.Client.Jar = context.CookieJar
+	}
+
+	return httpClient
+}
+
+// a retry would follow the same redirects, so requests that fail with it aren't retried
+var errTooManyRedirects = errors.New("reached --max-redirects")
+
+// redirectPolicy fails the request once it has been redirected more than maxRedirects times,
+// a redirect to another host is returned as the response when sameHost is set
+func redirectPolicy(maxRedirects int, sameHost bool) func(*http.Request, []*http.Request) error {
+	return func(request *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects, %w", maxRedirects, errTooManyRedirects)
+		}
+
+		if sameHost && !strings.EqualFold(request.URL.Hostname(), via[0].URL.Hostname()) {
+			return http.ErrUseLastResponse
+		}
+
+		return nil
+	}
+}
+
+// returns the 3xx response as-is
+func noFollowRedirects(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// every connection is made to the socket, the url's host is only used for the Host header (and TLS)
+func useUnixSocket(transport *http.Transport, unixSocket string) {
+	dialer := &net.Dialer{}
+	transport.Proxy = nil
+	transport.DialContext = func(dialContext ctx.Context, _ string, _ string) (net.Conn, error) {
+		return dialer.DialContext(dialContext, "unix", unixSocket)
+	}
+}
+
+// clientFor returns the worker's client, or a copy of it when the request overrides the timeout,
+// redirect policy or unix socket.  The copy shares the worker's transport unless it needs to
+// dial a different socket, as pooled connections are only keyed by the url's scheme and host
+func (httpClient *HttpClient) clientFor(overrides *parser.RequestOverrides) *http.Client {
+	if overrides == nil || (overrides.Timeout == 0 && overrides.FollowRedirects == nil && overrides.UnixSocket == "") {
+		return httpClient.Client
+	}
+
+	client := *httpClient.Client
+
+	if overrides.Timeout > 0 {
+		client.Timeout = overrides.Timeout
+	}
+
+	if overrides.FollowRedirects != nil {
+		if *overrides.FollowRedirects {
+			client.CheckRedirect = httpClient.followRedirects
+		} else {
+			client.CheckRedirect = noFollowRedirects
+		}
 	}
+
+	if overrides.UnixSocket != "" {
+		client.Transport = httpClient.unixSocketTransport(overrides.UnixSocket)
+	}
+
+	return &client
 }
 
+// the pool's transport for the socket, wrapped like the worker's own transport for --verbose
+func (httpClient *HttpClient) unixSocketTransport(unixSocket string) http.RoundTripper {
+	transport := httpClient.pool.unixSocketTransport(unixSocket)
+
+	if verbose, ok := httpClient.Client.Transport.(*verboseTransport); ok {
+		return &verboseTransport{transport: transport, logger: verbose.logger}
+	}
+	return transport
+}
+
+// StartRequestWorkers starts the workers that send each request, once dispatchContext is cancelled
+// pending retries are abandoned but requests already sent finish unless sendContext is also cancelled.
+// The workers are spread round-robin over --connection-pools connection pools
 func StartRequestWorkers(
+	dispatchContext ctx.Context,
+	sendContext ctx.Context,
 	requestsWithContext <-chan parser.RequestWithContext,
 	responsesWithContext chan<- *responses.ResponseWithContext,
 	rateLimitTicker *time.Ticker,
+	failureTracker *FailureTracker,
+	stats *RunStats,
 	context *execcontext.Context,
 ) *sync.WaitGroup {
 	var requestWaitGroup sync.WaitGroup
 	requestWaitGroup.Add(context.RequestWorkers)
 
-	for i := 1; i <= context.RequestWorkers; i++ {
+	pools := make([]*connectionPool, max(1, min(context.ConnectionPools, context.RequestWorkers)))
+	for i := range pools {
+		pools[i] = newConnectionPool(context)
+	}
+
+	for i := 0; i < context.RequestWorkers; i++ {
+		httpClient := newHttpClient(context, pools[i%len(pools)], stats)
 		go func() {
-			requestWorker(context, requestsWithContext, responsesWithContext, rateLimitTicker)
+			requestWorker(dispatchContext, sendContext, context, httpClient, requestsWithContext, responsesWithContext, rateLimitTicker, failureTracker)
 			requestWaitGroup.Done()
 		}()
 	}
@@ -58,29 +234,65 @@ func StartRequestWorkers(
 }
 
 func requestWorker(
+	dispatchContext ctx.Context,
+	sendContext ctx.Context,
 	context *execcontext.Context,
+	httpClient *HttpClient,
 	requestsWithContext <-chan parser.RequestWithContext,
 	responsesWithContext chan<- *responses.ResponseWithContext,
 	rateLimitTicker *time.Ticker,
+	failureTracker *FailureTracker,
 ) {
-	httpClient := NewHttpClient(context)
-
 	for requestWithContext := range requestsWithContext {
+		// once the run is stopped the requests still queued are skipped rather than sent
+		if dispatchContext.Err() != nil {
+			logSkipped(httpClient.Logger, requestWithContext)
+			continue
+		}
+
 		if rateLimitTicker != nil {
-			<-rateLimitTicker.C // wait for the next tick to send the request
+			// wait for the next tick to send the request
+			select {
+			case <-rateLimitTicker.C:
+			case <-dispatchContext.Done():
+				logSkipped(httpClient.Logger, requestWithContext)
+				continue
+			}
 		}
 
-		finalResponse, err := requestWithRetry(httpClient, requestWithContext, context.BaseRetryDelayDuration)
+		if dispatchContext.Err() != nil {
+			logSkipped(httpClient.Logger, requestWithContext)
+			continue
+		}
+
+		requestWithContext.Request = requestWithContext.Request.WithContext(sendContext)
+		finalResponse, err := requestWithRetry(dispatchContext, httpClient, requestWithContext, context.BaseRetryDelayDuration)
+		failureTracker.Record(err != nil)
+		httpClient.stats.recordRequest(err != nil)
 
 		if err != nil {
-			httpClient.Logger.LogError(err, requestWithContext.Request.URL.String())
+			url := requestWithContext.Request.URL.Redacted()
+			httpClient.Logger.LogError(err, url, finalResponse.LogAttrs(url)...)
 		} else {
 			responsesWithContext <- finalResponse
 		}
 	}
 }
 
+// logSkipped names a request that was read from the input but not sent, with its context, so
+// the input can be picked back up from it
+func logSkipped(logger *logger.LeveledLogger, requestWithContext parser.RequestWithContext) {
+	message := requestWithContext.Request.URL.Redacted()
+	if requestWithContext.RequestContext != nil {
+		if requestContext, err := json.Marshal(requestWithContext.RequestContext); err == nil {
+			message += " " + string(requestContext)
+		}
+	}
+	logger.Warn("Skipped: %s, the run was stopped before it was sent", message)
+}
+
 func requestWithRetry(
+	dispatchContext ctx.Context,
 	httpClient *HttpClient,
 	requestWithContext parser.RequestWithContext,
 	baseRetryDelay time.Duration,
@@ -88,12 +300,107 @@ func requestWithRetry(
 	var response *http.Response
 	var err error
 
+	client := httpClient.clientFor(requestWithContext.Overrides)
+	maxRetries := httpClient.MaxRetries
+	outputFile := ""
+
+	if overrides := requestWithContext.Overrides; overrides != nil {
+		if overrides.Retries != nil {
+			maxRetries = *overrides.Retries
+		}
+		outputFile = overrides.OutputFile
+	}
+
+	// the url's own credentials and an Authorization header from the flags or JSON line take precedence
+	request := requestWithContext.Request
+	authenticate := false
+	if request.URL.User == nil && request.Header.Get("Authorization") == "" {
+		if httpClient.authenticator != nil {
+			authenticate = true
+		} else if httpClient.netrc != nil {
+			if login, password, ok := httpClient.netrc.Lookup(request.URL.Hostname()); ok {
+				request.SetBasicAuth(login, password)
+			}
+		}
+	}
+	reauthenticated := false
+
+	// the client adds the jar's cookies to the request's Cookie header each time it's sent, so the
+	// header is put back the way it was before each attempt
+	cookieHeader := slices.Clone(request.Header["Cookie"])
+
+	var sourceAddress string
+	if httpClient.recordSourceAddress || httpClient.stats != nil {
+		requestWithContext.Request = requestWithContext.Request.WithContext(httptrace.WithClientTrace(
+			requestWithContext.Request.Context(),
+			&httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) {
+					httpClient.stats.recordConnection(info.Reused)
+					if httpClient.recordSourceAddress {
+						sourceAddress, _, _ = net.SplitHostPort(info.Conn.LocalAddr().String())
+					}
+				},
+			},
+		))
+	}
+
 	for attempts := 1; ; attempts++ {
-		response, err = httpClient.Client.Do(requestWithContext.Request)
+		// replayable bodies are recreated for each attempt, file bodies are opened here rather than when parsed
+		if request := requestWithContext.Request; request.GetBody != nil {
+			request.Body, err = request.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("unable to read request body: %w", err)
+			}
+		}
+
+		if client.Jar != nil {
+			if cookieHeader == nil {
+				requestWithContext.Request.Header.Del("Cookie")
+			} else {
+				requestWithContext.Request.Header["Cookie"] = slices.Clone(cookieHeader)
+			}
+		}
+
+		var authorization string
+		if authenticate {
+			authorization, err = httpClient.authenticator.Authorization(requestWithContext.Request.Context())
+			if err != nil {
+				return nil, err
+			}
+			requestWithContext.Request.Header.Set("Authorization", authorization)
+		}
+
+		if httpClient.signer != nil {
+			if err = httpClient.signer.Sign(requestWithContext.Request); err != nil {
+				return nil, err
+			}
+		}
+
+		start := time.Now()
+		response, err = client.Do(requestWithContext.Request)
+		duration := time.Since(start)
+
+		// the token may have been revoked or expired early, get a new one and send the request
+		// again once without counting it as a retry
+		if authenticate && !reauthenticated && err == nil && response.StatusCode == http.StatusUnauthorized {
+			reauthenticated = true
+			response.Body.Close()
+			httpClient.authenticator.Invalidate(authorization)
+			attempts--
+			continue
+		}
 
 		responseWithContext := &responses.ResponseWithContext{
 			Response:       response,
 			RequestContext: requestWithContext.RequestContext,
+			OutputFile:     outputFile,
+			SourceAddress:  sourceAddress,
+			Attempt:        attempts,
+			Duration:       duration,
+		}
+
+		if err == nil && httpClient.recordProtocol {
+			responseWithContext.Protocol = response.Proto
 		}
 
 		if err == nil && response.StatusCode < 500 {
@@ -101,24 +408,35 @@ func requestWithRetry(
 			return responseWithContext, nil
 		}
 
-		message := requestWithContext.Request.URL.String()
+		if errors.Is(err, errTooManyRedirects) {
+			return responseWithContext, err
+		}
+
+		message := requestWithContext.Request.URL.Redacted()
 
 		if err == nil {
-			httpClient.Logger.LogResponse(response.StatusCode, message)
+			httpClient.Logger.LogResponse(response.StatusCode, message, responseWithContext.LogAttrs(message)...)
 			response.Body.Close()
 		} else {
-			httpClient.Logger.LogError(err, message)
+			httpClient.Logger.LogError(err, message, responseWithContext.LogAttrs(message)...)
 		}
 
-		if attempts > httpClient.MaxRetries {
-			return responseWithContext, fmt.Errorf("maximum number of retries (%d) reached for request", httpClient.MaxRetries)
+		if attempts > maxRetries {
+			return responseWithContext, fmt.Errorf("maximum number of retries (%d) reached for request", maxRetries)
 		}
 
 		delay := baseRetryDelay * time.Duration(1<<attempts)
 		if delay > 30*time.Second {
 			delay = 30 * time.Second
 		}
-		time.Sleep(delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-dispatchContext.Done():
+			timer.Stop()
+			return responseWithContext, fmt.Errorf("abandoned retrying request: %w", dispatchContext.Err())
+		}
 	}
 
 }
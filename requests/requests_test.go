@@ -1,9 +1,13 @@
 package requests
 
 import (
+	"context"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -24,9 +28,14 @@ func newTestContext(retries int) *execcontext.Context {
 	return ctx
 }
 
+// a worker's client as StartRequestWorkers builds it, with a connection pool of its own
+func newTestClient(ctx *execcontext.Context) *HttpClient {
+	return newHttpClient(ctx, newConnectionPool(ctx), nil)
+}
+
 func TestNewHttpClient(t *testing.T) {
 	ctx := newTestContext(3)
-	client := NewHttpClient(ctx)
+	client := newTestClient(ctx)
 
 	assert.Equal(t, 3, client.MaxRetries)
 	assert.NotNil(t, client.Client)
@@ -41,12 +50,12 @@ func TestRequestWithRetrySuccess(t *testing.T) {
 	defer server.Close()
 
 	ctx := newTestContext(0)
-	client := NewHttpClient(ctx)
+	client := newTestClient(ctx)
 
 	req, _ := http.NewRequest("GET", server.URL, nil)
 	rwc := parser.RequestWithContext{Request: req}
 
-	resp, err := requestWithRetry(client, rwc, time.Millisecond)
+	resp, err := requestWithRetry(context.Background(), client, rwc, time.Millisecond)
 
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.Response.StatusCode)
@@ -64,12 +73,12 @@ func TestRequestWithRetryReturns4xxWithoutRetrying(t *testing.T) {
 	defer server.Close()
 
 	ctx := newTestContext(3)
-	client := NewHttpClient(ctx)
+	client := newTestClient(ctx)
 
 	req, _ := http.NewRequest("GET", server.URL, nil)
 	rwc := parser.RequestWithContext{Request: req}
 
-	resp, err := requestWithRetry(client, rwc, time.Millisecond)
+	resp, err := requestWithRetry(context.Background(), client, rwc, time.Millisecond)
 
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusNotFound, resp.Response.StatusCode)
@@ -91,12 +100,12 @@ func TestRequestWithRetryRetries5xx(t *testing.T) {
 	defer server.Close()
 
 	ctx := newTestContext(5)
-	client := NewHttpClient(ctx)
+	client := newTestClient(ctx)
 
 	req, _ := http.NewRequest("GET", server.URL, nil)
 	rwc := parser.RequestWithContext{Request: req}
 
-	resp, err := requestWithRetry(client, rwc, time.Millisecond)
+	resp, err := requestWithRetry(context.Background(), client, rwc, time.Millisecond)
 
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.Response.StatusCode)
@@ -115,12 +124,12 @@ func TestRequestWithRetryExhaustsRetries(t *testing.T) {
 	defer server.Close()
 
 	ctx := newTestContext(2)
-	client := NewHttpClient(ctx)
+	client := newTestClient(ctx)
 
 	req, _ := http.NewRequest("GET", server.URL, nil)
 	rwc := parser.RequestWithContext{Request: req}
 
-	_, err := requestWithRetry(client, rwc, time.Millisecond)
+	_, err := requestWithRetry(context.Background(), client, rwc, time.Millisecond)
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "maximum number of retries (2) reached")
@@ -128,6 +137,140 @@ func TestRequestWithRetryExhaustsRetries(t *testing.T) {
 	assert.Equal(t, 3, callCount)
 }
 
+func TestRequestWithRetryAbandonsRetriesWhenDispatchCancelled(t *testing.T) {
+	callCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx := newTestContext(5)
+	client := newTestClient(ctx)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	rwc := parser.RequestWithContext{Request: req}
+
+	dispatchContext, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := requestWithRetry(dispatchContext, client, rwc, time.Hour)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Minute, "retry delay should be cut short")
+	assert.Equal(t, 1, callCount)
+}
+
+func TestRequestWithRetryUsesOverrides(t *testing.T) {
+	callCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx := newTestContext(5)
+	client := newTestClient(ctx)
+
+	retries := 1
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	rwc := parser.RequestWithContext{Request: req, Overrides: &parser.RequestOverrides{Retries: &retries, OutputFile: "out.json"}}
+
+	resp, err := requestWithRetry(context.Background(), client, rwc, time.Millisecond)
+
+	assert.EqualError(t, err, "maximum number of retries (1) reached for request")
+	assert.Equal(t, 2, callCount, "per-request retries replace the batch retries")
+	assert.Equal(t, "out.json", resp.OutputFile)
+}
+
+func TestRequestWithRetryResendsBodyOnRetry(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	ctx := newTestContext(1)
+	client := newTestClient(ctx)
+
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	req.ContentLength = 8
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("the body")), nil
+	}
+	rwc := parser.RequestWithContext{Request: req}
+
+	resp, err := requestWithRetry(context.Background(), client, rwc, time.Millisecond)
+
+	assert.NoError(t, err)
+	resp.Response.Body.Close()
+	assert.Equal(t, []string{"the body", "the body"}, bodies)
+}
+
+func TestClientForOverrides(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/target", http.StatusFound)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	ctx := newTestContext(0)
+	client := newTestClient(ctx)
+
+	assert.Same(t, client.Client, client.clientFor(nil))
+	assert.Same(t, client.Client, client.clientFor(&parser.RequestOverrides{OutputFile: "out.json"}))
+
+	timeoutClient := client.clientFor(&parser.RequestOverrides{Timeout: time.Millisecond})
+	assert.Same(t, client.Client.Transport, timeoutClient.Transport, "overridden clients share the connection pool")
+	_, err := timeoutClient.Get(server.URL + "/target")
+	assert.ErrorContains(t, err, "Client.Timeout exceeded")
+
+	followRedirects := false
+	resp, err := client.clientFor(&parser.RequestOverrides{FollowRedirects: &followRedirects}).Get(server.URL + "/redirect")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestClientForUnixSocket(t *testing.T) {
+	directory, _ := os.MkdirTemp("", "ganda")
+	defer os.RemoveAll(directory)
+	socket := filepath.Join(directory, "api.sock")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("socket " + r.Host + r.URL.Path))
+	}))
+	server.Listener, _ = net.Listen("unix", socket)
+	server.Start()
+	defer server.Close()
+
+	ctx := newTestContext(0)
+	pool := newConnectionPool(ctx)
+	client := newHttpClient(ctx, pool, nil)
+	otherWorker := newHttpClient(ctx, pool, nil)
+
+	socketClient := client.clientFor(&parser.RequestOverrides{UnixSocket: socket})
+	assert.NotSame(t, client.Client.Transport, socketClient.Transport, "sockets don't share the tcp connection pool")
+	assert.Same(t, socketClient.Transport, client.clientFor(&parser.RequestOverrides{UnixSocket: socket}).Transport)
+	assert.Same(t, socketClient.Transport, otherWorker.clientFor(&parser.RequestOverrides{UnixSocket: socket}).Transport, "workers on a pool share its socket transports")
+	assert.NotSame(t, socketClient.Transport, newHttpClient(ctx, newConnectionPool(ctx), nil).clientFor(&parser.RequestOverrides{UnixSocket: socket}).Transport)
+
+	resp, err := socketClient.Get("http://api.internal/status")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "socket api.internal/status", string(body))
+}
+
 func TestStartRequestWorkers(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -141,7 +284,7 @@ func TestStartRequestWorkers(t *testing.T) {
 	requestsChan := make(chan parser.RequestWithContext, 2)
 	responsesChan := make(chan *responses.ResponseWithContext, 2)
 
-	wg := StartRequestWorkers(requestsChan, responsesChan, nil, ctx)
+	wg := StartRequestWorkers(context.Background(), context.Background(), requestsChan, responsesChan, nil, nil, nil, ctx)
 
 	req1, _ := http.NewRequest("GET", server.URL+"/a", nil)
 	req2, _ := http.NewRequest("GET", server.URL+"/b", nil)
@@ -184,7 +327,7 @@ func TestStartRequestWorkersWithRateLimiting(t *testing.T) {
 	ticker := time.NewTicker(10 * time.Millisecond)
 	defer ticker.Stop()
 
-	wg := StartRequestWorkers(requestsChan, responsesChan, ticker, ctx)
+	wg := StartRequestWorkers(context.Background(), context.Background(), requestsChan, responsesChan, ticker, nil, nil, ctx)
 
 	for i := 0; i < 3; i++ {
 		req, _ := http.NewRequest("GET", server.URL, nil)
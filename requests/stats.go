@@ -0,0 +1,44 @@
+package requests
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// RunStats is shared by the request workers to count requests and how often an idle
+// connection was reused rather than a new one opened, a nil RunStats records nothing
+type RunStats struct {
+	requests          atomic.Int64
+	failures          atomic.Int64
+	connectionsOpened atomic.Int64
+	connectionsReused atomic.Int64
+}
+
+func (s *RunStats) recordRequest(failed bool) {
+	if s == nil {
+		return
+	}
+
+	s.requests.Add(1)
+	if failed {
+		s.failures.Add(1)
+	}
+}
+
+// called for every attempt, including retries and redirects
+func (s *RunStats) recordConnection(reused bool) {
+	if s == nil {
+		return
+	}
+
+	if reused {
+		s.connectionsReused.Add(1)
+	} else {
+		s.connectionsOpened.Add(1)
+	}
+}
+
+func (s *RunStats) String() string {
+	return fmt.Sprintf("%d requests (%d failed), %d connections opened, %d connections reused",
+		s.requests.Load(), s.failures.Load(), s.connectionsOpened.Load(), s.connectionsReused.Load())
+}
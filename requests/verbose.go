@@ -0,0 +1,71 @@
+package requests
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tednaleid/ganda/logger"
+)
+
+// verboseTransport logs every request it sends and the response to it for --verbose, including
+// each redirect.  The headers are the ones on the request, the transport adds User-Agent and
+// Accept-Encoding as it sends them when they're missing
+type verboseTransport struct {
+	transport http.RoundTripper
+	logger    *logger.LeveledLogger
+}
+
+func (t *verboseTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if t.logger.Verbosity() >= 2 && request.GetBody != nil {
+		// the transport reads the request's own body, so the start of a copy is logged
+		if body, err := request.GetBody(); err == nil {
+			requestBody, _ = io.ReadAll(io.LimitReader(body, logger.VerboseBodyLimit+1))
+			body.Close()
+		}
+	}
+
+	t.logger.LogRequest(request.Method, request.URL.Redacted(), requestHeaders(request), requestBody)
+
+	response, err := t.transport.RoundTrip(request)
+	if err != nil {
+		return response, err
+	}
+
+	var responseBody []byte
+	if t.logger.Verbosity() >= 2 {
+		responseBody, response.Body = peekBody(response.Body)
+	}
+
+	// Status is the code and the reason phrase the server sent, ex: 200 OK
+	reason := strings.TrimPrefix(response.Status, strconv.Itoa(response.StatusCode)+" ")
+	t.logger.LogResponseHeaders(response.Proto, response.StatusCode, reason, request.URL.Redacted(), response.Header, responseBody)
+
+	return response, nil
+}
+
+// the Host header isn't in the request's headers
+func requestHeaders(request *http.Request) http.Header {
+	header := request.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if request.Host != "" {
+		header.Set("Host", request.Host)
+	} else {
+		header.Set("Host", request.URL.Host)
+	}
+	return header
+}
+
+// peekBody reads the start of the body and returns a body that still reads all of it
+func peekBody(body io.ReadCloser) ([]byte, io.ReadCloser) {
+	start, _ := io.ReadAll(io.LimitReader(body, logger.VerboseBodyLimit+1))
+	return start, struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(start), body), body}
+}
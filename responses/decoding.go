@@ -0,0 +1,85 @@
+package responses
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// wraps the emit function so the response body is decoded from its Content-Encoding first,
+// the transport only decodes gzip itself when it set the Accept-Encoding header, which it
+// doesn't do once we've advertised our own encodings
+func decodingEmitResponseFn(emitResponse emitResponseWithContextFn) emitResponseWithContextFn {
+	return func(responseWithContext *ResponseWithContext, out io.Writer) (bytesWritten int64, err error) {
+		if err := decodeBody(responseWithContext.Response); err != nil {
+			responseWithContext.Response.Body.Close()
+			return 0, err
+		}
+		return emitResponse(responseWithContext, out)
+	}
+}
+
+// decodeBody replaces the response body with a reader that decodes it and removes the
+// Content-Encoding and Content-Length headers as they no longer describe the body
+func decodeBody(response *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return nil
+	}
+
+	var decoder io.Reader
+	var closeDecoder func()
+	var err error
+
+	switch encoding {
+	case "gzip", "x-gzip":
+		var gzipReader *gzip.Reader
+		gzipReader, err = gzip.NewReader(response.Body)
+		decoder = gzipReader
+	case "br":
+		decoder = brotli.NewReader(response.Body)
+	case "zstd":
+		var zstdDecoder *zstd.Decoder
+		zstdDecoder, err = zstd.NewReader(response.Body, zstd.WithDecoderConcurrency(1))
+		decoder = zstdDecoder
+		if zstdDecoder != nil {
+			closeDecoder = zstdDecoder.Close
+		}
+	default:
+		return fmt.Errorf("unsupported response Content-Encoding: %s", encoding)
+	}
+
+	// gzip reads its header up front, an empty body (ex: a HEAD request) has nothing to decode
+	if errors.Is(err, io.EOF) {
+		decoder = strings.NewReader("")
+	} else if err != nil {
+		return fmt.Errorf("unable to decode %s response body: %w", encoding, err)
+	}
+
+	response.Body = &decodedBody{Reader: decoder, body: response.Body, closeDecoder: closeDecoder}
+	response.Header.Del("Content-Encoding")
+	response.Header.Del("Content-Length")
+	response.ContentLength = -1
+	response.Uncompressed = true
+
+	return nil
+}
+
+type decodedBody struct {
+	io.Reader
+	body         io.ReadCloser
+	closeDecoder func()
+}
+
+func (d *decodedBody) Close() error {
+	if d.closeDecoder != nil {
+		d.closeDecoder()
+	}
+	return d.body.Close()
+}
@@ -0,0 +1,87 @@
+package responses
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/tednaleid/ganda/config"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	testCases := []struct {
+		encoding  string
+		newWriter func(out io.Writer) io.WriteCloser
+	}{
+		{"gzip", func(out io.Writer) io.WriteCloser { return gzip.NewWriter(out) }},
+		{"br", func(out io.Writer) io.WriteCloser { return brotli.NewWriter(out) }},
+		{"zstd", func(out io.Writer) io.WriteCloser { encoder, _ := zstd.NewWriter(out); return encoder }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.encoding, func(t *testing.T) {
+			compressed := new(bytes.Buffer)
+			writer := tc.newWriter(compressed)
+			writer.Write([]byte("\"hello world\""))
+			writer.Close()
+
+			mockResponse := NewMockResponseBodyOnly(compressed.String())
+			mockResponse.Header = http.Header{"Content-Encoding": {tc.encoding}, "Content-Length": {"123"}}
+
+			responseFn := decodingEmitResponseFn(determineEmitJsonResponseWithContextFn(config.Raw))
+			writeCloser := NewMockWriteCloser()
+
+			_, err := responseFn(&ResponseWithContext{Response: mockResponse.Response}, writeCloser)
+
+			assert.NoError(t, err)
+			assert.True(t, mockResponse.BodyClosed())
+			assert.Equal(t, "{ \"url\": \"http://example.com\", \"code\": 200, \"body\": \"hello world\" }", writeCloser.ToString())
+			assert.Empty(t, mockResponse.Header.Get("Content-Encoding"))
+			assert.Empty(t, mockResponse.Header.Get("Content-Length"))
+		})
+	}
+}
+
+func TestDecodeBodyWithoutContentEncoding(t *testing.T) {
+	mockResponse := NewMockResponseBodyOnly("hello world")
+
+	responseFn := decodingEmitResponseFn(determineEmitResponseFn(config.Raw))
+	writeCloser := NewMockWriteCloser()
+
+	_, err := responseFn(&ResponseWithContext{Response: mockResponse.Response}, writeCloser)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", writeCloser.ToString())
+}
+
+func TestDecodeEmptyGzipBody(t *testing.T) {
+	mockResponse := NewMockResponseBodyOnly("")
+	mockResponse.Header = http.Header{"Content-Encoding": {"gzip"}}
+
+	responseFn := decodingEmitResponseFn(determineEmitJsonResponseWithContextFn(config.Raw))
+	writeCloser := NewMockWriteCloser()
+
+	_, err := responseFn(&ResponseWithContext{Response: mockResponse.Response}, writeCloser)
+
+	assert.NoError(t, err)
+	assert.True(t, mockResponse.BodyClosed())
+	assert.Equal(t, "{ \"url\": \"http://example.com\", \"code\": 200, \"body\": null }", writeCloser.ToString())
+}
+
+func TestDecodeUnsupportedEncoding(t *testing.T) {
+	mockResponse := NewMockResponseBodyOnly("compressed")
+	mockResponse.Header = http.Header{"Content-Encoding": {"compress"}}
+
+	responseFn := decodingEmitResponseFn(determineEmitResponseFn(config.Raw))
+	writeCloser := NewMockWriteCloser()
+
+	_, err := responseFn(&ResponseWithContext{Response: mockResponse.Response}, writeCloser)
+
+	assert.EqualError(t, err, "unsupported response Content-Encoding: compress")
+	assert.True(t, mockResponse.BodyClosed())
+	assert.Equal(t, "", writeCloser.ToString())
+}
@@ -12,10 +12,14 @@ import (
 	"github.com/tednaleid/ganda/execcontext"
 	"hash"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sync"
+	"time"
 )
 
 var specialCharactersRegexp = regexp.MustCompile("[^A-Za-z0-9]+")
@@ -23,6 +27,33 @@ var specialCharactersRegexp = regexp.MustCompile("[^A-Za-z0-9]+")
 type ResponseWithContext struct {
 	Response       *http.Response
 	RequestContext interface{}
+	OutputFile     string        // overrides the filename derived from the url when saving to files
+	SourceAddress  string        // the local address the request was sent from when using --source-address
+	Protocol       string        // the negotiated protocol, ex: HTTP/2.0, when an http version flag is used
+	Attempt        int           // which attempt this response is from, 1 unless the request was retried
+	Duration       time.Duration // how long the attempt took to get the response headers
+}
+
+// LogAttrs are the fields added to the structured log events about the response, a nil
+// response only has the url
+func (responseWithContext *ResponseWithContext) LogAttrs(url string) []slog.Attr {
+	attrs := []slog.Attr{slog.String("url", url)}
+	if responseWithContext == nil {
+		return attrs
+	}
+	if responseWithContext.Attempt > 0 {
+		attrs = append(attrs, slog.Int("attempt", responseWithContext.Attempt))
+	}
+	if responseWithContext.Duration > 0 {
+		attrs = append(attrs, slog.Int64("duration_ms", responseWithContext.Duration.Milliseconds()))
+	}
+	return attrs
+}
+
+// inputURL is the url the request was made for, before any redirects were followed, with its password redacted
+func (responseWithContext *ResponseWithContext) inputURL() string {
+	originalUrl, _ := redirectChain(responseWithContext.Response)
+	return originalUrl.Redacted()
 }
 
 func StartResponseWorkers(responsesWithContext <-chan *ResponseWithContext, context *execcontext.Context) *sync.WaitGroup {
@@ -38,6 +69,11 @@ func StartResponseWorkers(responsesWithContext <-chan *ResponseWithContext, cont
 				emitResponse = determineEmitResponseFn(context.ResponseBody)
 			}
 
+			// saved files can keep the bytes exactly as the server compressed them
+			if context.AcceptEncoding != "" && !(context.WriteFiles && context.SaveCompressed) {
+				emitResponse = decodingEmitResponseFn(emitResponse)
+			}
+
 			if context.WriteFiles {
 				responseSavingWorker(responsesWithContext, context, emitResponse)
 			} else {
@@ -60,20 +96,29 @@ func responseSavingWorker(
 ) {
 	responseWorker(responsesWithContext, func(responseWithContext *ResponseWithContext) {
 		response := responseWithContext.Response
-		filename := specialCharactersRegexp.ReplaceAllString(response.Request.URL.String(), "-")
-		writeableFile, err := createWritableFile(context.BaseDirectory, context.SubdirLength, filename)
+
+		var writeableFile *WritableFile
+		var err error
+		if responseWithContext.OutputFile != "" {
+			writeableFile, err = createNamedWritableFile(context.BaseDirectory, responseWithContext.OutputFile)
+		} else {
+			filename := specialCharactersRegexp.ReplaceAllString(response.Request.URL.String(), "-")
+			writeableFile, err = createWritableFile(context.BaseDirectory, context.SubdirLength, filename)
+		}
+		url := responseWithContext.inputURL()
 		if err != nil {
-			context.Logger.LogError(err, response.Request.URL.String())
+			context.Logger.LogError(err, url, responseWithContext.LogAttrs(url)...)
 			return
 		}
 		defer writeableFile.WriteCloser.Close()
 
 		_, err = emitResponseWithContextFn(responseWithContext, writeableFile.WriteCloser)
 
+		attrs := append(responseWithContext.LogAttrs(url), slog.String("file", writeableFile.FullPath))
 		if err != nil {
-			context.Logger.LogError(err, response.Request.URL.String()+" -> "+writeableFile.FullPath)
+			context.Logger.LogError(err, url+" -> "+writeableFile.FullPath, attrs...)
 		} else {
-			context.Logger.LogResponse(response.StatusCode, response.Request.URL.String()+" -> "+writeableFile.FullPath)
+			context.Logger.LogResponse(response.StatusCode, url+" -> "+writeableFile.FullPath, attrs...)
 		}
 	})
 }
@@ -92,10 +137,11 @@ func responsePrintingWorker(
 		response := responseWithContext.Response
 		bytesWritten, err := emitResponseWithContext(responseWithContext, out)
 
+		url := responseWithContext.inputURL()
 		if err != nil {
-			context.Logger.LogError(err, response.Request.URL.String())
+			context.Logger.LogError(err, url, responseWithContext.LogAttrs(url)...)
 		} else {
-			context.Logger.LogResponse(response.StatusCode, response.Request.URL.String())
+			context.Logger.LogResponse(response.StatusCode, url, responseWithContext.LogAttrs(url)...)
 			if bytesWritten > 0 {
 				out.Write(newline)
 			}
@@ -134,16 +180,35 @@ func jsonEnvelopeResponseFn(bodyResponseFn emitResponseFn, responseBody config.R
 
 		requestContext := responseWithContext.RequestContext
 
+		// the url is always the one from the input, the redirects array lists each hop to the final response
+		originalUrl, redirects := redirectChain(response)
+
 		// everything before emitting the body response
 		bytesWritten, err = appendString(0, out, fmt.Sprintf(
-			"{ \"url\": \"%s\", \"code\": %d, \"body\": ",
-			response.Request.URL.String(),
+			"{ \"url\": \"%s\", \"code\": %d, ",
+			originalUrl.String(),
 			response.StatusCode,
 		))
 		if err != nil {
 			return bytesWritten, err
 		}
 
+		if len(redirects) > 0 {
+			redirectsJson, err := json.Marshal(redirects)
+			if err != nil {
+				return bytesWritten, err
+			}
+			bytesWritten, err = appendString(bytesWritten, out, fmt.Sprintf("\"redirects\": %s, ", redirectsJson))
+			if err != nil {
+				return bytesWritten, err
+			}
+		}
+
+		bytesWritten, err = appendString(bytesWritten, out, "\"body\": ")
+		if err != nil {
+			return bytesWritten, err
+		}
+
 		// emit the body response
 		if responseBody == config.Discard || responseBody == config.Raw || responseBody == config.Escaped {
 			// no need to wrap either of these in quotes, Raw is assumed to be JSON
@@ -189,6 +254,22 @@ func jsonEnvelopeResponseFn(bodyResponseFn emitResponseFn, responseBody config.R
 			}
 		}
 
+		if responseWithContext.Protocol != "" {
+			protocolBytesWritten, err := appendString(bytesWritten, out, fmt.Sprintf(", \"protocol\": \"%s\"", responseWithContext.Protocol))
+			bytesWritten += protocolBytesWritten
+			if err != nil {
+				return bytesWritten, err
+			}
+		}
+
+		if responseWithContext.SourceAddress != "" {
+			sourceBytesWritten, err := appendString(bytesWritten, out, fmt.Sprintf(", \"sourceAddress\": \"%s\"", responseWithContext.SourceAddress))
+			bytesWritten += sourceBytesWritten
+			if err != nil {
+				return bytesWritten, err
+			}
+		}
+
 		// close out the JSON envelope
 		closingBytesWritten, err = appendString(bytesWritten, out, " }")
 		bytesWritten += closingBytesWritten
@@ -200,6 +281,28 @@ func jsonEnvelopeResponseFn(bodyResponseFn emitResponseFn, responseBody config.R
 	}
 }
 
+type redirect struct {
+	Code     int    `json:"code"`
+	Location string `json:"location"`
+}
+
+// redirectChain walks back from the final request to the one made for the input url, the http
+// client sets each redirected request's Response to the response that redirected it
+func redirectChain(response *http.Response) (*url.URL, []redirect) {
+	request := response.Request
+	var redirects []redirect
+
+	for request.Response != nil && request.Response.Request != nil {
+		redirects = append([]redirect{{
+			Code:     request.Response.StatusCode,
+			Location: request.Response.Header.Get("Location"),
+		}}, redirects...)
+		request = request.Response.Request
+	}
+
+	return request.URL, redirects
+}
+
 // writes a string to the writer and updates the number of bytes written
 func appendString(bytesPreviouslyWritten int64, out io.Writer, s string) (int64, error) {
 	appendedBytes, err := fmt.Fprint(out, s)
@@ -322,6 +425,19 @@ func createWritableFile(baseDirectory string, subdirLength int, filename string)
 	return &WritableFile{FullPath: fullPath, WriteCloser: file}, nil
 }
 
+// creates the file at the path given by the request, relative to the base directory and without a hashed subdirectory
+func createNamedWritableFile(baseDirectory string, outputFile string) (*WritableFile, error) {
+	fullPath := filepath.Join(baseDirectory, outputFile)
+	os.MkdirAll(filepath.Dir(fullPath), os.ModePerm)
+
+	file, err := os.Create(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file %s: %w", fullPath, err)
+	}
+
+	return &WritableFile{FullPath: fullPath, WriteCloser: file}, nil
+}
+
 func directoryForFile(baseDirectory string, filename string, subdirLength int) string {
 	var directory string
 	if subdirLength <= 0 {